@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/exp/rand"
+)
+
+const maxBotsPerRoom = 8
+
+// BotProfile describes how fast and how steadily a bot types.
+type BotProfile struct {
+	Name        string
+	WPM         float64
+	Variance    float64 // standard deviation of the per-word speed, relative to WPM
+	PauseChance float64 // probability of hesitating before a word
+	PauseMin    time.Duration
+	PauseMax    time.Duration
+}
+
+var botProfiles = map[string]BotProfile{
+	"beginner": {Name: "beginner", WPM: 30, Variance: 0.35, PauseChance: 0.12, PauseMin: 600 * time.Millisecond, PauseMax: 2 * time.Second},
+	"casual":   {Name: "casual", WPM: 50, Variance: 0.25, PauseChance: 0.08, PauseMin: 400 * time.Millisecond, PauseMax: 1500 * time.Millisecond},
+	"average":  {Name: "average", WPM: 70, Variance: 0.2, PauseChance: 0.05, PauseMin: 300 * time.Millisecond, PauseMax: 1200 * time.Millisecond},
+	"fast":     {Name: "fast", WPM: 100, Variance: 0.15, PauseChance: 0.03, PauseMin: 250 * time.Millisecond, PauseMax: 900 * time.Millisecond},
+	"pro":      {Name: "pro", WPM: 140, Variance: 0.1, PauseChance: 0.02, PauseMin: 200 * time.Millisecond, PauseMax: 600 * time.Millisecond},
+}
+
+// wordDelay is how long the bot needs to type word and the following space.
+func (p BotProfile) wordDelay(word string, rng *rand.Rand) time.Duration {
+	chars := utf8.RuneCountInString(word) + 1
+	base := float64(chars) / 5 / p.WPM * float64(time.Minute)
+	jitter := 1 + rng.NormFloat64()*p.Variance
+	if jitter < 0.25 {
+		jitter = 0.25
+	}
+	delay := time.Duration(base * jitter)
+	if rng.Float64() < p.PauseChance {
+		delay += p.PauseMin + time.Duration(rng.Int63n(int64(p.PauseMax-p.PauseMin)+1))
+	}
+	return delay
+}
+
+// addBot puts a bot into the room of client, which has to be its host. The
+// message content may pick a profile and override its WPM: {"profile":
+// "fast", "wpm": 90}.
+func (gs *GameServer) addBot(client *Client, messageContent json.RawMessage) {
+	if client.room == "" {
+		gs.sendError(client, "join a room before adding bots")
+		return
+	}
+	var options struct {
+		Profile string  `json:"profile"`
+		WPM     float64 `json:"wpm"`
+	}
+	if len(messageContent) > 0 {
+		if err := json.Unmarshal(messageContent, &options); err != nil {
+			gs.sendError(client, "invalid bot options")
+			return
+		}
+	}
+	if options.Profile == "" {
+		options.Profile = "average"
+	}
+	profile, ok := botProfiles[options.Profile]
+	if !ok {
+		gs.sendError(client, fmt.Sprintf("unknown bot profile %q", options.Profile))
+		return
+	}
+	if options.WPM > 0 {
+		profile.WPM = options.WPM
+	}
+
+	username := fmt.Sprintf("Bot_%s_%d", profile.Name, time.Now().UnixNano()%10000)
+	bot := &Client{
+		id:       fmt.Sprintf("%s_%d", username, time.Now().UnixNano()),
+		username: username,
+		room:     client.room,
+		outbox:   newOutbox(gs.cfg.SendBufferSize, gs.cfg.SlowClientMaxDropped),
+		isReady:  true,
+		isBot:    true,
+	}
+
+	gs.mutex.Lock()
+	if gs.roomHostLocked(bot.room) != client {
+		gs.mutex.Unlock()
+		gs.sendError(client, "only the host of the room can add bots")
+		return
+	}
+	bots := 0
+	for _, member := range gs.rooms[bot.room] {
+		if member.isBot {
+			bots++
+		}
+	}
+	if bots >= maxBotsPerRoom {
+		gs.mutex.Unlock()
+		gs.sendError(client, "this room already has the maximum number of bots")
+		return
+	}
+	gs.clients[bot.id] = bot
+	gs.rooms[bot.room][bot.id] = bot
+	gs.mutex.Unlock()
+
+	go gs.runBot(bot, profile)
+
+	if game, exists := gs.games[bot.room]; exists && game.IsActive {
+		gs.joinRunningGame(bot)
+	} else {
+		gs.roomStatus(bot)
+	}
+}
+
+// removeBot takes one bot out of the room of client, which has to be its
+// host.
+func (gs *GameServer) removeBot(client *Client) {
+	gs.mutex.Lock()
+	if gs.roomHostLocked(client.room) != client {
+		gs.mutex.Unlock()
+		gs.sendError(client, "only the host of the room can remove bots")
+		return
+	}
+	var bot *Client
+	for _, member := range gs.rooms[client.room] {
+		if member.isBot {
+			bot = member
+			break
+		}
+	}
+	if bot != nil {
+		gs.removeBotLocked(bot)
+	}
+	gs.mutex.Unlock()
+	if bot == nil {
+		gs.sendError(client, "there is no bot in this room")
+		return
+	}
+	gs.roomStatus(client)
+}
+
+// removeBotLocked unregisters bot and stops its typing. The caller must hold
+// gs.mutex.
+func (gs *GameServer) removeBotLocked(bot *Client) {
+	delete(gs.rooms[bot.room], bot.id)
+	delete(gs.clients, bot.id)
+	if game, exists := gs.games[bot.room]; exists {
+		delete(game.InGameUsers, bot.id)
+	}
+	bot.outbox.close("")
+}
+
+// removeIdleBotsLocked drops the bots of a room once no human is left in it.
+// The caller must hold gs.mutex.
+func (gs *GameServer) removeIdleBotsLocked(room string) {
+	for _, member := range gs.rooms[room] {
+		if !member.isBot {
+			return
+		}
+	}
+	for _, bot := range gs.rooms[room] {
+		gs.removeBotLocked(bot)
+	}
+}
+
+// runBot consumes the messages sent to a bot and starts typing whenever a
+// race starts. It returns once the bot is unregistered and its outbox closed.
+func (gs *GameServer) runBot(bot *Client, profile BotProfile) {
+	var stop chan struct{}
+	for {
+		message, ok := bot.outbox.next()
+		if !ok {
+			break
+		}
+		var start struct {
+			Type   string   `json:"type"`
+			Words  []string `json:"words"`
+			Time   int64    `json:"startTime"`
+			Mode   string   `json:"mode"`
+			Runner string   `json:"runner"`
+		}
+		if err := json.Unmarshal(message, &start); err != nil {
+			continue
+		}
+		// In relay races a bot only types its own legs, from the moment
+		// they are handed over.
+		switch {
+		case start.Type == "startGame" && start.Mode != modeRelay:
+		case start.Type == "relayLeg" && start.Runner == bot.username:
+			start.Time = time.Now().UTC().UnixMilli()
+		default:
+			continue
+		}
+		if stop != nil {
+			close(stop)
+		}
+		stop = make(chan struct{})
+		go gs.botType(bot, profile, start.Words, start.Time, start.Mode, stop)
+	}
+	if stop != nil {
+		close(stop)
+	}
+}
+
+func (gs *GameServer) botType(bot *Client, profile BotProfile, words []string, startTime int64, mode string, stop chan struct{}) {
+	rng := rand.New(rand.NewSource(uint64(time.Now().UnixNano())))
+	select {
+	case <-time.After(time.Until(time.UnixMilli(startTime))):
+	case <-stop:
+		return
+	}
+	for _, word := range words {
+		select {
+		case <-time.After(profile.wordDelay(word, rng)):
+		case <-stop:
+			return
+		}
+		if game, exists := gs.games[bot.room]; !exists || !game.IsActive {
+			return
+		}
+		if mode == modeCode {
+			content, _ := json.Marshal(map[string]string{"line": word})
+			gs.lineComplete(bot, content)
+			continue
+		}
+		content, _ := json.Marshal(map[string]string{"word": word})
+		gs.wordComplete(bot, content)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Broker connects the nodes of a cluster. It carries the messages between
+// nodes and decides which node owns a room; every node runs the race of the
+// rooms it owns and forwards its clients' messages for other rooms to their
+// owner.
+type Broker interface {
+	// Publish sends payload to every subscriber of topic.
+	Publish(ctx context.Context, topic string, payload []byte) error
+	// Subscribe calls handler for every message published to topic, one at a
+	// time and in order, until cancel is called.
+	Subscribe(ctx context.Context, topic string, handler func(payload []byte)) (cancel func(), err error)
+	// ClaimRoom makes node the owner of room for ttl unless another node
+	// already owns it, and returns the owner.
+	ClaimRoom(ctx context.Context, room string, node string, ttl time.Duration) (owner string, err error)
+	// RenewRoom extends the claim of node on room. It reports false when
+	// node does not own room anymore.
+	RenewRoom(ctx context.Context, room string, node string, ttl time.Duration) (bool, error)
+	// ReleaseRoom gives up the claim of node on room.
+	ReleaseRoom(ctx context.Context, room string, node string) error
+	Close() error
+}
+
+// newBroker creates the broker selected by cfg.Broker.
+func newBroker(cfg *Config) (Broker, error) {
+	if cfg.Broker == "redis" {
+		return newRedisBroker(cfg.RedisURL)
+	}
+	return newMemoryBroker(), nil
+}
+
+// memoryBroker is a Broker for nodes running in the same process, which is
+// what a single server is.
+type memoryBroker struct {
+	mutex       sync.Mutex
+	subscribers map[string]map[*memorySubscription]struct{}
+	owners      map[string]roomClaim
+}
+
+type memorySubscription struct {
+	messages chan []byte
+	done     chan struct{}
+}
+
+type roomClaim struct {
+	node    string
+	expires time.Time
+}
+
+func newMemoryBroker() *memoryBroker {
+	return &memoryBroker{
+		subscribers: make(map[string]map[*memorySubscription]struct{}),
+		owners:      make(map[string]roomClaim),
+	}
+}
+
+func (b *memoryBroker) Publish(ctx context.Context, topic string, payload []byte) error {
+	b.mutex.Lock()
+	subscriptions := make([]*memorySubscription, 0, len(b.subscribers[topic]))
+	for subscription := range b.subscribers[topic] {
+		subscriptions = append(subscriptions, subscription)
+	}
+	b.mutex.Unlock()
+	for _, subscription := range subscriptions {
+		select {
+		case subscription.messages <- payload:
+		case <-subscription.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (b *memoryBroker) Subscribe(ctx context.Context, topic string, handler func(payload []byte)) (func(), error) {
+	subscription := &memorySubscription{messages: make(chan []byte, 1024), done: make(chan struct{})}
+	b.mutex.Lock()
+	if b.subscribers[topic] == nil {
+		b.subscribers[topic] = make(map[*memorySubscription]struct{})
+	}
+	b.subscribers[topic][subscription] = struct{}{}
+	b.mutex.Unlock()
+
+	go func() {
+		for {
+			select {
+			case payload := <-subscription.messages:
+				handler(payload)
+			case <-subscription.done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mutex.Lock()
+			delete(b.subscribers[topic], subscription)
+			b.mutex.Unlock()
+			close(subscription.done)
+		})
+	}, nil
+}
+
+func (b *memoryBroker) ClaimRoom(ctx context.Context, room string, node string, ttl time.Duration) (string, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	claim, ok := b.owners[room]
+	if ok && claim.node != node && time.Now().Before(claim.expires) {
+		return claim.node, nil
+	}
+	b.owners[room] = roomClaim{node: node, expires: time.Now().Add(ttl)}
+	return node, nil
+}
+
+func (b *memoryBroker) RenewRoom(ctx context.Context, room string, node string, ttl time.Duration) (bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	claim, ok := b.owners[room]
+	if !ok || claim.node != node || time.Now().After(claim.expires) {
+		return false, nil
+	}
+	b.owners[room] = roomClaim{node: node, expires: time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (b *memoryBroker) ReleaseRoom(ctx context.Context, room string, node string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if claim, ok := b.owners[room]; ok && claim.node == node {
+		delete(b.owners, room)
+	}
+	return nil
+}
+
+func (b *memoryBroker) Close() error {
+	return nil
+}
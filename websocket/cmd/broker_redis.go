@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisBroker shares rooms between nodes through Redis: messages go over
+// pub/sub and room ownership is a key with an expiry per room.
+type redisBroker struct {
+	client *redis.Client
+}
+
+const redisKeyPrefix = "elevenfingers:"
+
+// renewScript and releaseScript only touch the claim if node still holds it.
+var (
+	renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+func newRedisBroker(url string) (*redisBroker, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(options)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &redisBroker{client: client}, nil
+}
+
+func roomKey(room string) string {
+	return redisKeyPrefix + "room:" + room
+}
+
+func (b *redisBroker) Publish(ctx context.Context, topic string, payload []byte) error {
+	return b.client.Publish(ctx, redisKeyPrefix+topic, payload).Err()
+}
+
+func (b *redisBroker) Subscribe(ctx context.Context, topic string, handler func(payload []byte)) (func(), error) {
+	subscription := b.client.Subscribe(ctx, redisKeyPrefix+topic)
+	// Wait for the confirmation so nothing published after Subscribe returns is missed.
+	if _, err := subscription.Receive(ctx); err != nil {
+		subscription.Close()
+		return nil, err
+	}
+	messages := subscription.Channel()
+	go func() {
+		for message := range messages {
+			handler([]byte(message.Payload))
+		}
+	}()
+	return func() { subscription.Close() }, nil
+}
+
+func (b *redisBroker) ClaimRoom(ctx context.Context, room string, node string, ttl time.Duration) (string, error) {
+	claimed, err := b.client.SetNX(ctx, roomKey(room), node, ttl).Result()
+	if err != nil {
+		return "", err
+	}
+	if claimed {
+		return node, nil
+	}
+	owner, err := b.client.Get(ctx, roomKey(room)).Result()
+	if errors.Is(err, redis.Nil) {
+		// The claim expired in between, try again.
+		return b.ClaimRoom(ctx, room, node, ttl)
+	}
+	return owner, err
+}
+
+func (b *redisBroker) RenewRoom(ctx context.Context, room string, node string, ttl time.Duration) (bool, error) {
+	renewed, err := renewScript.Run(ctx, b.client, []string{roomKey(room)}, node, ttl.Milliseconds()).Int()
+	return renewed == 1, err
+}
+
+func (b *redisBroker) ReleaseRoom(ctx context.Context, room string, node string) error {
+	return releaseScript.Run(ctx, b.client, []string{roomKey(room)}, node).Err()
+}
+
+func (b *redisBroker) Close() error {
+	return b.client.Close()
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestRedisBroker runs against the Redis at REDIS_URL, e.g.
+// REDIS_URL=redis://127.0.0.1:6379/0 go test ./...
+func TestRedisBroker(t *testing.T) {
+	url := os.Getenv("REDIS_URL")
+	if url == "" {
+		t.Skip("REDIS_URL is not set")
+	}
+	broker, err := newRedisBroker(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	testBroker(t, broker, fmt.Sprintf("test-%d-", time.Now().UnixNano()))
+}
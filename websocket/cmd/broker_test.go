@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testBroker checks the claim, renew, release and publish semantics every
+// Broker implementation has to share. Rooms and topics are named after prefix
+// so runs against a shared Redis don't see each other.
+func testBroker(t *testing.T, broker Broker, prefix string) {
+	ctx := context.Background()
+	const ttl = time.Minute
+
+	t.Run("claim", func(t *testing.T) {
+		room := prefix + "claim"
+		for _, claim := range []struct{ node, owner string }{{"a", "a"}, {"b", "a"}, {"a", "a"}} {
+			owner, err := broker.ClaimRoom(ctx, room, claim.node, ttl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if owner != claim.owner {
+				t.Fatalf("claim by %s: owner %q, want %q", claim.node, owner, claim.owner)
+			}
+		}
+	})
+
+	t.Run("renew", func(t *testing.T) {
+		room := prefix + "renew"
+		if renewed, err := broker.RenewRoom(ctx, room, "a", ttl); err != nil || renewed {
+			t.Fatalf("renewing an unclaimed room: %v, %v", renewed, err)
+		}
+		broker.ClaimRoom(ctx, room, "a", ttl)
+		if renewed, err := broker.RenewRoom(ctx, room, "a", ttl); err != nil || !renewed {
+			t.Fatalf("renewing by the owner: %v, %v", renewed, err)
+		}
+		if renewed, err := broker.RenewRoom(ctx, room, "b", ttl); err != nil || renewed {
+			t.Fatalf("renewing by another node: %v, %v", renewed, err)
+		}
+	})
+
+	t.Run("release", func(t *testing.T) {
+		room := prefix + "release"
+		broker.ClaimRoom(ctx, room, "a", ttl)
+		if err := broker.ReleaseRoom(ctx, room, "b"); err != nil {
+			t.Fatal(err)
+		}
+		if owner, _ := broker.ClaimRoom(ctx, room, "b", ttl); owner != "a" {
+			t.Fatalf("released by another node: owner %q, want a", owner)
+		}
+		if err := broker.ReleaseRoom(ctx, room, "a"); err != nil {
+			t.Fatal(err)
+		}
+		if owner, _ := broker.ClaimRoom(ctx, room, "b", ttl); owner != "b" {
+			t.Fatalf("released by the owner: owner %q, want b", owner)
+		}
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		room := prefix + "expiry"
+		broker.ClaimRoom(ctx, room, "a", 50*time.Millisecond)
+		time.Sleep(150 * time.Millisecond)
+		if owner, _ := broker.ClaimRoom(ctx, room, "b", ttl); owner != "b" {
+			t.Fatalf("claim after expiry: owner %q, want b", owner)
+		}
+		if renewed, _ := broker.RenewRoom(ctx, room, "a", ttl); renewed {
+			t.Fatal("the expired owner renewed its claim")
+		}
+	})
+
+	t.Run("publish", func(t *testing.T) {
+		topic := prefix + "topic"
+		first, second := make(chan string, 10), make(chan string, 10)
+		cancelFirst, err := broker.Subscribe(ctx, topic, func(payload []byte) { first <- string(payload) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		cancelSecond, err := broker.Subscribe(ctx, topic, func(payload []byte) { second <- string(payload) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cancelSecond()
+		other := make(chan string, 10)
+		cancelOther, _ := broker.Subscribe(ctx, prefix+"other", func(payload []byte) { other <- string(payload) })
+		defer cancelOther()
+
+		for i := range 3 {
+			if err := broker.Publish(ctx, topic, []byte(fmt.Sprint(i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for name, messages := range map[string]chan string{"first": first, "second": second} {
+			for i := range 3 {
+				select {
+				case got := <-messages:
+					if got != fmt.Sprint(i) {
+						t.Fatalf("%s subscriber got %q, want %d", name, got, i)
+					}
+				case <-time.After(2 * time.Second):
+					t.Fatalf("%s subscriber missed message %d", name, i)
+				}
+			}
+		}
+
+		cancelFirst()
+		broker.Publish(ctx, topic, []byte("after cancel"))
+		select {
+		case <-second:
+		case <-time.After(2 * time.Second):
+			t.Fatal("second subscriber missed the message after the first cancelled")
+		}
+		select {
+		case got := <-first:
+			t.Fatalf("cancelled subscriber got %q", got)
+		case got := <-other:
+			t.Fatalf("subscriber of another topic got %q", got)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+}
+
+func TestMemoryBroker(t *testing.T) {
+	testBroker(t, newMemoryBroker(), "")
+}
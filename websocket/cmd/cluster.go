@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// envelope is what nodes send each other on their node topic. A client
+// connected to one node (its home) that joins a room owned by another node
+// is attached there as a remote client: its messages are forwarded to the
+// owner and everything the owner queues for it is delivered back home.
+type envelope struct {
+	Kind          string          `json:"kind"` // attach, message, detach, deliver, close or moved
+	Node          string          `json:"node"` // sender
+	Client        string          `json:"client"`
+	Room          string          `json:"room,omitempty"` // of moved
+	Username      string          `json:"username,omitempty"`
+	Authenticated bool            `json:"authenticated,omitempty"`
+	Data          json.RawMessage `json:"data,omitempty"`
+	Reason        string          `json:"reason,omitempty"`
+}
+
+func nodeTopic(node string) string {
+	return "node:" + node
+}
+
+// StartCluster subscribes to the messages for this node and keeps the claims
+// on its rooms alive until ctx is done.
+func (gs *GameServer) StartCluster(ctx context.Context) error {
+	cancel, err := gs.broker.Subscribe(ctx, nodeTopic(gs.cfg.NodeID), gs.handleEnvelope)
+	if err != nil {
+		return err
+	}
+	go func() {
+		defer cancel()
+		ticker := time.NewTicker(gs.cfg.RoomOwnershipTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				gs.renewRooms(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// roomOwner returns the node that runs room, claiming it for this node when
+// nobody does. If the broker can't be reached the room is run locally.
+func (gs *GameServer) roomOwner(room string) string {
+	gs.mutex.Lock()
+	owned := gs.owned[room]
+	gs.mutex.Unlock()
+	if owned {
+		return gs.cfg.NodeID
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	owner, err := gs.broker.ClaimRoom(ctx, room, gs.cfg.NodeID, gs.cfg.RoomOwnershipTTL)
+	if err != nil {
+		gs.log.Error("claiming room failed", "room", room, "err", err)
+		return gs.cfg.NodeID
+	}
+	if owner == gs.cfg.NodeID {
+		gs.mutex.Lock()
+		gs.owned[room] = true
+		gs.mutex.Unlock()
+		gs.log.Info("room claimed", "room", room)
+	}
+	return owner
+}
+
+// renewRooms extends the claims on the rooms this node runs and releases the
+// ones nobody is in anymore.
+func (gs *GameServer) renewRooms(ctx context.Context) {
+	gs.mutex.Lock()
+	var keep, release []string
+	for room := range gs.owned {
+		game, exists := gs.games[room]
+		if len(gs.rooms[room]) > 0 || (exists && game.IsActive) {
+			keep = append(keep, room)
+		} else {
+			release = append(release, room)
+			delete(gs.owned, room)
+		}
+	}
+	gs.mutex.Unlock()
+
+	for _, room := range release {
+		if err := gs.broker.ReleaseRoom(ctx, room, gs.cfg.NodeID); err != nil {
+			gs.log.Warn("releasing room failed", "room", room, "err", err)
+		}
+	}
+	for _, room := range keep {
+		renewed, err := gs.broker.RenewRoom(ctx, room, gs.cfg.NodeID, gs.cfg.RoomOwnershipTTL)
+		if err != nil {
+			gs.log.Warn("renewing room claim failed", "room", room, "err", err)
+		} else if !renewed {
+			gs.log.Error("lost ownership of room", "room", room)
+			gs.loseRoom(room)
+		}
+	}
+}
+
+// loseRoom gives up a room whose claim ran out, so that only the node that
+// owns it now runs it. The local race is ended with reason moved, bots are
+// dropped and every player joins the room again: local clients end up at
+// the new owner, clients of other nodes are sent back to their home node
+// to do the same.
+func (gs *GameServer) loseRoom(room string) {
+	gs.mutex.Lock()
+	delete(gs.owned, room)
+	gs.mutex.Unlock()
+	if game, exists := gs.games[room]; exists && game.IsActive {
+		gs.closeMatch(room, "moved")
+		endGameMessage, _ := json.Marshal(&GameMessage{Type: "endGame"})
+		gs.broadcastToRoom(room, endGameMessage)
+	}
+
+	gs.mutex.Lock()
+	var members []*Client
+	for _, member := range gs.rooms[room] {
+		if member.isBot {
+			gs.removeBotLocked(member)
+		} else {
+			members = append(members, member)
+		}
+	}
+	gs.mutex.Unlock()
+	for _, member := range members {
+		if member.homeNode == "" {
+			gs.rejoin(member, room)
+			continue
+		}
+		gs.publish(member.homeNode, envelope{Kind: "moved", Client: member.id, Room: room})
+		gs.mutex.Lock()
+		delete(gs.remotes, member.id)
+		gs.mutex.Unlock()
+		gs.unregister <- member
+	}
+}
+
+// rejoin joins client to room again, wherever room is run now.
+func (gs *GameServer) rejoin(client *Client, room string) {
+	content, _ := json.Marshal(map[string]string{"room": room})
+	message, _ := json.Marshal(map[string]any{"type": "join", "content": json.RawMessage(content)})
+	gs.logFor(client).Info("joining moved room again")
+	if !gs.routeMessage(client, "join", message) {
+		gs.joinPlayer(client, content)
+	}
+}
+
+// ReleaseRooms gives up every room claim, so other nodes can take over the
+// rooms right away after a shutdown.
+func (gs *GameServer) ReleaseRooms(ctx context.Context) {
+	gs.mutex.Lock()
+	rooms := make([]string, 0, len(gs.owned))
+	for room := range gs.owned {
+		rooms = append(rooms, room)
+	}
+	gs.owned = make(map[string]bool)
+	gs.mutex.Unlock()
+	for _, room := range rooms {
+		gs.broker.ReleaseRoom(ctx, room, gs.cfg.NodeID)
+	}
+}
+
+// routeMessage forwards a message of a local client to the node that owns its
+// room. It reports false when the message is for a room run by this node.
+func (gs *GameServer) routeMessage(client *Client, messageType string, message []byte) bool {
+	if messageType == "join" {
+		var content struct {
+			Content struct {
+				Room string `json:"room"`
+			} `json:"content"`
+		}
+		json.Unmarshal(message, &content)
+		owner := gs.roomOwner(content.Content.Room)
+		if client.ownerNode != "" && client.ownerNode != owner {
+			gs.detachRemote(client)
+		}
+		if owner == gs.cfg.NodeID {
+			return false
+		}
+		if client.ownerNode == "" {
+			gs.mutex.Lock()
+			delete(gs.rooms[client.room], client.id)
+			gs.forwarded[client.id] = client
+			gs.mutex.Unlock()
+			gs.publish(owner, envelope{
+				Kind:          "attach",
+				Client:        client.id,
+				Username:      client.username,
+				Authenticated: client.authenticated,
+			})
+			client.ownerNode = owner
+		}
+		client.room = content.Content.Room
+		gs.logFor(client).Info("client joined remote room", "owner", owner)
+	}
+	if client.ownerNode == "" {
+		return false
+	}
+	gs.publish(client.ownerNode, envelope{Kind: "message", Client: client.id, Data: message})
+	return true
+}
+
+// detachRemote tells the owner of the room of client that it left.
+func (gs *GameServer) detachRemote(client *Client) {
+	if client.ownerNode == "" {
+		return
+	}
+	gs.mutex.Lock()
+	delete(gs.forwarded, client.id)
+	gs.mutex.Unlock()
+	gs.publish(client.ownerNode, envelope{Kind: "detach", Client: client.id})
+	client.ownerNode = ""
+}
+
+func (gs *GameServer) publish(node string, message envelope) {
+	message.Node = gs.cfg.NodeID
+	payload, _ := json.Marshal(message)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := gs.broker.Publish(ctx, nodeTopic(node), payload); err != nil {
+		gs.log.Error("publishing to node failed", "node", node, "kind", message.Kind, "err", err)
+	}
+}
+
+func (gs *GameServer) handleEnvelope(payload []byte) {
+	var message envelope
+	if err := json.Unmarshal(payload, &message); err != nil {
+		gs.log.Warn("invalid cluster message", "err", err)
+		return
+	}
+	clusterMessages.WithLabelValues(message.Kind).Inc()
+	switch message.Kind {
+	case "attach":
+		client := &Client{
+			id:            message.Client,
+			username:      message.Username,
+			authenticated: message.Authenticated,
+			outbox:        newOutbox(gs.cfg.SendBufferSize, gs.cfg.SlowClientMaxDropped),
+			homeNode:      message.Node,
+		}
+		gs.mutex.Lock()
+		gs.remotes[client.id] = client
+		gs.mutex.Unlock()
+		go gs.forwardOutbox(client)
+	case "message":
+		gs.mutex.Lock()
+		client, ok := gs.remotes[message.Client]
+		gs.mutex.Unlock()
+		if ok {
+			gs.handleGameMessage(client, message.Data)
+		}
+	case "detach":
+		gs.mutex.Lock()
+		client, ok := gs.remotes[message.Client]
+		delete(gs.remotes, message.Client)
+		gs.mutex.Unlock()
+		if ok {
+			gs.unregister <- client
+			client.outbox.close("")
+		}
+	case "moved":
+		gs.mutex.Lock()
+		client, ok := gs.forwarded[message.Client]
+		delete(gs.forwarded, message.Client)
+		gs.mutex.Unlock()
+		if ok {
+			client.ownerNode = ""
+			gs.rejoin(client, message.Room)
+		}
+	case "deliver", "close":
+		gs.mutex.Lock()
+		client, ok := gs.forwarded[message.Client]
+		gs.mutex.Unlock()
+		if !ok {
+			return
+		}
+		if message.Kind == "deliver" {
+			gs.send(client, message.Data)
+		} else {
+			client.outbox.close(message.Reason)
+		}
+	}
+}
+
+// forwardOutbox delivers what is queued for a remote client to its home node.
+func (gs *GameServer) forwardOutbox(client *Client) {
+	for {
+		message, ok := client.outbox.next()
+		if !ok {
+			if reason := client.outbox.closeReason(); reason != "" {
+				gs.publish(client.homeNode, envelope{Kind: "close", Client: client.id, Reason: reason})
+			}
+			return
+		}
+		gs.publish(client.homeNode, envelope{Kind: "deliver", Client: client.id, Data: message})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func newTestNode(t *testing.T, ctx context.Context, broker Broker, node string) *GameServer {
+	t.Helper()
+	cfg := defaultConfig()
+	cfg.NodeID, cfg.DataDir, cfg.TextsDir, cfg.APIURL = node, "", "../texts", ""
+	for code, lang := range cfg.Languages {
+		lang.Code = code
+	}
+	runs, _ := NewRunStore("")
+	gs := NewGameServer(cfg, runs, broker, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := gs.LoadTexts(); err != nil {
+		t.Fatal(err)
+	}
+	go gs.Run()
+	if err := gs.StartCluster(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return gs
+}
+
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if condition() {
+			return
+		}
+	}
+	t.Fatal(what)
+}
+
+// A node whose claim on a room was taken over must stop running the room and
+// send its players to the new owner.
+func TestLostRoomMovesToNewOwner(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := newMemoryBroker()
+	a, b := newTestNode(t, ctx, broker, "a"), newTestNode(t, ctx, broker, "b")
+
+	client := &Client{id: "client", username: "player", outbox: newOutbox(256, 0)}
+	a.rejoin(client, "room1")
+	a.startNewGame("room1")
+	game := a.games["room1"]
+	if game == nil || !game.IsActive {
+		t.Fatal("no race started on a")
+	}
+
+	broker.ReleaseRoom(ctx, "room1", "a")
+	broker.ClaimRoom(ctx, "room1", "b", time.Minute)
+	a.renewRooms(ctx)
+
+	a.mutex.Lock()
+	owned := a.owned["room1"]
+	a.mutex.Unlock()
+	if owned {
+		t.Fatal("a still owns room1")
+	}
+	if game.IsActive {
+		t.Fatal("the race on a is still running")
+	}
+	if client.ownerNode != "b" {
+		t.Fatalf("client plays on %q, want b", client.ownerNode)
+	}
+	eventually(t, "client never joined room1 on b", func() bool {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		return b.rooms["room1"]["client"] != nil
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/exp/rand"
+	"golang.org/x/text/unicode/norm"
+)
+
+var codeLanguagePattern = regexp.MustCompile(`^[a-z0-9+#-]{1,16}$`)
+
+// randomSnippet picks a snippet from SnippetsDir/<language> with rng. With an
+// empty language any language directory is used. It returns the language and
+// the normalized snippet.
+func (gs *GameServer) randomSnippet(language string, rng *rand.Rand) (string, string, error) {
+	if language == "" {
+		entries, err := os.ReadDir(gs.cfg.SnippetsDir)
+		if err != nil {
+			return "", "", err
+		}
+		var languages []string
+		for _, entry := range entries {
+			if entry.IsDir() && codeLanguagePattern.MatchString(entry.Name()) {
+				languages = append(languages, entry.Name())
+			}
+		}
+		if len(languages) == 0 {
+			return "", "", fmt.Errorf("no snippets in %s", gs.cfg.SnippetsDir)
+		}
+		language = languages[rng.Intn(len(languages))]
+	}
+	dir := filepath.Join(gs.cfg.SnippetsDir, language)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", "", err
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			files = append(files, entry.Name())
+		}
+	}
+	if len(files) == 0 {
+		return "", "", fmt.Errorf("no snippets in %s", dir)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, files[rng.Intn(len(files))]))
+	if err != nil {
+		return "", "", err
+	}
+	snippet := normalizeCode(string(data))
+	if snippet == "" {
+		return "", "", fmt.Errorf("empty snippet in %s", dir)
+	}
+	return language, snippet, nil
+}
+
+// normalizeCode is normalizeText for source code: line breaks and
+// indentation survive, trailing whitespace and blank lines at the start and
+// end do not.
+func normalizeCode(text string) string {
+	text = norm.NFC.String(strings.ToValidUTF8(text, ""))
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\t':
+			return r
+		case r == '\r':
+			return '\n'
+		case unicode.IsControl(r):
+			return -1
+		}
+		return r
+	}, text)
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRightFunc(line, unicode.IsSpace)
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
+
+// codeLines splits a normalized snippet into the lines players type, with
+// their indentation. Blank lines are skipped.
+func codeLines(snippet string) []string {
+	var lines []string
+	for _, line := range strings.Split(snippet, "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// sameLine compares a typed line with the expected one. Indentation is
+// optional since most editors insert it on their own.
+func sameLine(expected string, typed string) bool {
+	trim := func(line string) string {
+		return strings.TrimRightFunc(strings.TrimLeft(line, " \t"), unicode.IsSpace)
+	}
+	return trim(expected) == trim(typed)
+}
+
+// lineComplete is what wordComplete is for the other modes: code races are
+// judged line by line, {"line": "    return nil"}.
+func (gs *GameServer) lineComplete(client *Client, messageContent json.RawMessage) {
+	var result struct {
+		Line string `json:"line"`
+	}
+	if err := json.Unmarshal(messageContent, &result); err != nil {
+		gs.logFor(client).Warn("invalid lineComplete message", "err", err)
+		return
+	}
+	game, exists := gs.games[client.room]
+	if !exists || game.mode != modeCode {
+		return
+	}
+	line := result.Line
+	if record, ok := game.PlayerProgress[client.id]; ok && len(record.remainedWords) > 0 && sameLine(record.remainedWords[0], line) {
+		line = record.remainedWords[0]
+	}
+	gs.completeWord(client, game, line)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeCode(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+		want string
+	}{
+		{"indentation kept", "func f() {\n\treturn\n}", "func f() {\n\treturn\n}"},
+		{"trailing whitespace", "a := 1  \nb := 2\t\n", "a := 1\nb := 2"},
+		{"windows line breaks", "a\r\nb\rc", "a\nb\nc"},
+		{"blank lines around", "\n\n  \nx\n\n", "x"},
+		{"blank lines inside kept", "a\n\nb", "a\n\nb"},
+		{"control characters", "a\u0000b\u0007", "ab"},
+	}
+	for _, test := range tests {
+		if got := normalizeCode(test.code); got != test.want {
+			t.Errorf("%s: normalizeCode(%q) = %q, want %q", test.name, test.code, got, test.want)
+		}
+	}
+}
+
+func TestCodeLines(t *testing.T) {
+	snippet := "func f() {\n\n\tif x {\n\t\treturn\n\t}\n  \n}"
+	want := []string{"func f() {", "\tif x {", "\t\treturn", "\t}", "}"}
+	if got := codeLines(snippet); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSameLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		typed    string
+		want     bool
+	}{
+		{"same", "\treturn nil", "\treturn nil", true},
+		{"no indentation", "\t\treturn nil", "return nil", true},
+		{"spaces for tabs", "\treturn nil", "    return nil", true},
+		{"trailing whitespace", "return nil", "return nil  ", true},
+		{"inner spacing", "a := b", "a:=b", false},
+		{"different", "return nil", "return err", false},
+		{"case", "Return nil", "return nil", false},
+	}
+	for _, test := range tests {
+		if got := sameLine(test.expected, test.typed); got != test.want {
+			t.Errorf("%s: sameLine(%q, %q) = %v, want %v", test.name, test.expected, test.typed, got, test.want)
+		}
+	}
+}
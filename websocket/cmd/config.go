@@ -0,0 +1,505 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every tunable of the game server. Values are taken from the
+// defaults below, then the YAML file given by -config (or CONFIG_FILE), then
+// environment variables and finally command line flags.
+type Config struct {
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+	// APIURL is the base URL of the FastAPI service that verifies auth tokens.
+	// Leaving it empty disables token logins.
+	APIURL string `yaml:"apiURL"`
+
+	StartDelay     time.Duration `yaml:"startDelay"`     // countdown between startGame and the race start
+	AutoStartDelay time.Duration `yaml:"autoStartDelay"` // a room starts on its own this long after the first player joins
+	EndTimer       time.Duration `yaml:"endTimer"`       // time the others get once the first player finished
+	WordCount      int           `yaml:"wordCount"`
+	// SnippetsDir holds the code snippets of code races, one directory per
+	// programming language.
+	SnippetsDir string `yaml:"snippetsDir"`
+	// TextsDir holds the data files of the languages, see Language.
+	TextsDir string `yaml:"textsDir"`
+	// Languages are the languages rooms can race in, by code.
+	Languages map[string]*Language `yaml:"languages"`
+	// DefaultLanguage is the language of rooms that don't name one.
+	DefaultLanguage string `yaml:"defaultLanguage"`
+	// CustomTextMaxChars limits the texts room hosts submit, counted after
+	// normalization.
+	CustomTextMaxChars int `yaml:"customTextMaxChars"`
+	SendBufferSize     int `yaml:"sendBufferSize"`
+	// SlowClientMaxDropped disconnects a client once this many of its queued
+	// messages were dropped without the queue ever running empty. 0 never
+	// disconnects for dropped messages.
+	SlowClientMaxDropped int `yaml:"slowClientMaxDropped"`
+	// ProgressTick is how often a room broadcasts the batched raceProgress
+	// snapshot and relay teamProgress. 0 sends a userProgress or
+	// teamProgress message for every completed word.
+	ProgressTick time.Duration `yaml:"progressTick"`
+
+	DataDir string `yaml:"dataDir"` // recorded runs and match history, empty keeps them in memory
+	// SnapshotInterval is how often rooms and running races are saved to
+	// DataDir for the next start; 0 disables snapshots. Restored players
+	// have SessionResumeTimeout to reconnect and resume their session.
+	SnapshotInterval     time.Duration `yaml:"snapshotInterval"`
+	SessionResumeTimeout time.Duration `yaml:"sessionResumeTimeout"`
+	ShutdownTimeout      time.Duration `yaml:"shutdownTimeout"` // how long running races may go on after SIGTERM
+	ReconnectDelay       time.Duration `yaml:"reconnectDelay"`  // hint sent to clients on shutdown
+
+	// AllowedOrigins lists the origins browsers may connect from, like
+	// https://game.example.com or https://*.example.com for any subdomain.
+	// An empty list or "*" allows every origin.
+	AllowedOrigins         []string `yaml:"allowedOrigins"`
+	MaxConnectionsPerIP    int      `yaml:"maxConnectionsPerIP"`    // 0 means unlimited
+	MaxProtocolHeaderBytes int      `yaml:"maxProtocolHeaderBytes"` // size limit of Sec-WebSocket-Protocol
+	TrustProxyHeaders      bool     `yaml:"trustProxyHeaders"`      // take the client IP from X-Real-IP set by nginx
+
+	Rooms map[string]*RoomSettings `yaml:"rooms"`
+
+	// AdminToken is the bearer token organizers use to create and start
+	// tournaments over HTTP; empty disables that.
+	AdminToken           string        `yaml:"adminToken"`
+	TournamentJoinWindow time.Duration `yaml:"tournamentJoinWindow"` // a heat races at the latest this long after it opened
+	TournamentRaceBreak  time.Duration `yaml:"tournamentRaceBreak"`  // pause between the races of a heat
+
+	// Broker is memory for a single server or redis to share rooms between
+	// several nodes; each room is run by the node that claimed it first.
+	Broker           string        `yaml:"broker"`
+	RedisURL         string        `yaml:"redisURL"`
+	NodeID           string        `yaml:"nodeID"`           // defaults to the host name
+	RoomOwnershipTTL time.Duration `yaml:"roomOwnershipTTL"` // a room claim expires this long after its node stopped renewing it
+
+	// RateLimits caps how often a client may send each message type. Types
+	// without an entry share the "default" limit.
+	RateLimits              map[string]RateLimit `yaml:"rateLimits"`
+	ThrottleWarnAfter       int                  `yaml:"throttleWarnAfter"`       // dropped messages before the client gets an error
+	ThrottleDisconnectAfter int                  `yaml:"throttleDisconnectAfter"` // dropped messages before the client is disconnected, 0 never
+
+	LogLevel       string `yaml:"logLevel"`
+	LogFormat      string `yaml:"logFormat"`
+	LogSampleEvery uint64 `yaml:"logSampleEvery"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Host:                   "0.0.0.0",
+		Port:                   9000,
+		APIURL:                 "http://127.0.0.1:8000",
+		StartDelay:             5 * time.Second,
+		AutoStartDelay:         10 * time.Second,
+		EndTimer:               20 * time.Second,
+		WordCount:              10,
+		SnippetsDir:            "snippets",
+		TextsDir:               "texts",
+		DefaultLanguage:        "en",
+		CustomTextMaxChars:     2000,
+		SendBufferSize:         256,
+		SlowClientMaxDropped:   256,
+		ProgressTick:           200 * time.Millisecond,
+		DataDir:                "data",
+		SnapshotInterval:       5 * time.Second,
+		SessionResumeTimeout:   time.Minute,
+		ShutdownTimeout:        30 * time.Second,
+		ReconnectDelay:         5 * time.Second,
+		MaxConnectionsPerIP:    20,
+		MaxProtocolHeaderBytes: 4096,
+		Languages: map[string]*Language{
+			"en": {Name: "English", Direction: directionLTR, Punctuation: englishPunctuation},
+			"fa": {
+				Name:          "Persian",
+				Direction:     directionRTL,
+				Punctuation:   Punctuation{Comma: "،", Endings: []string{".", ".", ".", "؟", "!"}},
+				Normalization: TextNormalization{FoldArabic: true, ZWNJ: zwnjOptional, Digits: "persian"},
+			},
+		},
+		Rooms: map[string]*RoomSettings{
+			"room1": {},
+			"room2": {},
+			"room3": {Language: "fa"},
+		},
+		RateLimits: map[string]RateLimit{
+			defaultRateLimit: {Rate: 5, Burst: 10},
+			"wordComplete":   {Rate: 20, Burst: 40},
+			"roomsStatus":    {Rate: 2, Burst: 5},
+			"roomStatus":     {Rate: 2, Burst: 5},
+			"join":           {Rate: 1, Burst: 3},
+			"ready":          {Rate: 1, Burst: 3},
+			"startGame":      {Rate: 0.5, Burst: 2},
+			"usercred":       {Rate: 1, Burst: 3},
+			"customText":     {Rate: 0.2, Burst: 2},
+		},
+		ThrottleWarnAfter:       5,
+		ThrottleDisconnectAfter: 50,
+		TournamentJoinWindow:    2 * time.Minute,
+		TournamentRaceBreak:     15 * time.Second,
+		Broker:                  "memory",
+		RedisURL:                "redis://127.0.0.1:6379/0",
+		NodeID:                  hostname(),
+		RoomOwnershipTTL:        15 * time.Second,
+		LogLevel:                "info",
+		LogFormat:               "text",
+		LogSampleEvery:          50,
+	}
+}
+
+// Addr is the address the HTTP server listens on.
+func (c *Config) Addr() string {
+	return fmt.Sprintf("%s:%d", c.Host, c.Port)
+}
+
+// LoadConfig builds the configuration from the defaults, the config file,
+// the environment and the command line arguments, in that order.
+func LoadConfig(args []string) (*Config, error) {
+	cfg := defaultConfig()
+
+	flags := flag.NewFlagSet("elevenfingers", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file")
+	flagged := defaultConfig()
+	flags.StringVar(&flagged.Host, "host", flagged.Host, "interface to listen on")
+	flags.IntVar(&flagged.Port, "port", flagged.Port, "port to listen on")
+	flags.StringVar(&flagged.APIURL, "api-url", flagged.APIURL, "base URL of the auth API")
+	flags.DurationVar(&flagged.StartDelay, "start-delay", flagged.StartDelay, "countdown before a race starts")
+	flags.DurationVar(&flagged.AutoStartDelay, "auto-start-delay", flagged.AutoStartDelay, "delay before a room starts on its own")
+	flags.DurationVar(&flagged.EndTimer, "end-timer", flagged.EndTimer, "time left for the others once the first player finished")
+	flags.IntVar(&flagged.WordCount, "word-count", flagged.WordCount, "number of words in a race")
+	flags.IntVar(&flagged.SendBufferSize, "send-buffer", flagged.SendBufferSize, "outgoing messages buffered per client")
+	flags.DurationVar(&flagged.ProgressTick, "progress-tick", flagged.ProgressTick, "interval of batched progress broadcasts, 0 for one per word")
+	flags.StringVar(&flagged.DataDir, "data-dir", flagged.DataDir, "directory for recorded runs and match history")
+	flags.DurationVar(&flagged.ShutdownTimeout, "shutdown-timeout", flagged.ShutdownTimeout, "time running races get to finish on shutdown")
+	flags.DurationVar(&flagged.ReconnectDelay, "reconnect-delay", flagged.ReconnectDelay, "reconnect hint sent to clients on shutdown")
+	allowedOrigins := flags.String("allowed-origins", "", "comma separated origins allowed to connect")
+	flags.IntVar(&flagged.MaxConnectionsPerIP, "max-connections-per-ip", flagged.MaxConnectionsPerIP, "open sockets allowed per client IP, 0 for unlimited")
+	flags.BoolVar(&flagged.TrustProxyHeaders, "trust-proxy-headers", flagged.TrustProxyHeaders, "take the client IP from X-Real-IP")
+	flags.StringVar(&flagged.Broker, "broker", flagged.Broker, "memory or redis")
+	flags.StringVar(&flagged.RedisURL, "redis-url", flagged.RedisURL, "Redis server used by the redis broker")
+	flags.StringVar(&flagged.NodeID, "node-id", flagged.NodeID, "name of this node in the cluster")
+	flags.StringVar(&flagged.LogLevel, "log-level", flagged.LogLevel, "debug, info, warn or error")
+	flags.StringVar(&flagged.LogFormat, "log-format", flagged.LogFormat, "text or json")
+	flags.Uint64Var(&flagged.LogSampleEvery, "log-sample-every", flagged.LogSampleEvery, "keep one in n hot path log records")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		file, err := os.Open(*configFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading config file: %w", err)
+		}
+		decoder := yaml.NewDecoder(file)
+		decoder.KnownFields(true)
+		err = decoder.Decode(cfg)
+		file.Close()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("parsing config file %s: %w", *configFile, err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "host":
+			cfg.Host = flagged.Host
+		case "port":
+			cfg.Port = flagged.Port
+		case "api-url":
+			cfg.APIURL = flagged.APIURL
+		case "start-delay":
+			cfg.StartDelay = flagged.StartDelay
+		case "auto-start-delay":
+			cfg.AutoStartDelay = flagged.AutoStartDelay
+		case "end-timer":
+			cfg.EndTimer = flagged.EndTimer
+		case "word-count":
+			cfg.WordCount = flagged.WordCount
+		case "send-buffer":
+			cfg.SendBufferSize = flagged.SendBufferSize
+		case "progress-tick":
+			cfg.ProgressTick = flagged.ProgressTick
+		case "data-dir":
+			cfg.DataDir = flagged.DataDir
+		case "shutdown-timeout":
+			cfg.ShutdownTimeout = flagged.ShutdownTimeout
+		case "reconnect-delay":
+			cfg.ReconnectDelay = flagged.ReconnectDelay
+		case "allowed-origins":
+			cfg.AllowedOrigins = splitList(*allowedOrigins)
+		case "max-connections-per-ip":
+			cfg.MaxConnectionsPerIP = flagged.MaxConnectionsPerIP
+		case "trust-proxy-headers":
+			cfg.TrustProxyHeaders = flagged.TrustProxyHeaders
+		case "broker":
+			cfg.Broker = flagged.Broker
+		case "redis-url":
+			cfg.RedisURL = flagged.RedisURL
+		case "node-id":
+			cfg.NodeID = flagged.NodeID
+		case "log-level":
+			cfg.LogLevel = flagged.LogLevel
+		case "log-format":
+			cfg.LogFormat = flagged.LogFormat
+		case "log-sample-every":
+			cfg.LogSampleEvery = flagged.LogSampleEvery
+		}
+	})
+
+	for room, settings := range cfg.Rooms {
+		if settings == nil {
+			cfg.Rooms[room] = &RoomSettings{}
+		}
+	}
+	for code, lang := range cfg.Languages {
+		if lang == nil {
+			lang = &Language{}
+			cfg.Languages[code] = lang
+		}
+		lang.Code = code
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyEnv overrides the values whose environment variable is set.
+func (c *Config) applyEnv() error {
+	var errs []error
+	setString := func(name string, target *string) {
+		if value, ok := os.LookupEnv(name); ok {
+			*target = value
+		}
+	}
+	setInt := func(name string, target *int) {
+		if value, ok := os.LookupEnv(name); ok {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			*target = parsed
+		}
+	}
+	setUint := func(name string, target *uint64) {
+		if value, ok := os.LookupEnv(name); ok {
+			parsed, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			*target = parsed
+		}
+	}
+	setBool := func(name string, target *bool) {
+		if value, ok := os.LookupEnv(name); ok {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			*target = parsed
+		}
+	}
+	setDuration := func(name string, target *time.Duration) {
+		if value, ok := os.LookupEnv(name); ok {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			*target = parsed
+		}
+	}
+
+	setString("HOST", &c.Host)
+	setInt("PORT", &c.Port)
+	setString("API_URL", &c.APIURL)
+	setDuration("START_DELAY", &c.StartDelay)
+	setDuration("AUTO_START_DELAY", &c.AutoStartDelay)
+	setDuration("END_TIMER", &c.EndTimer)
+	setInt("WORD_COUNT", &c.WordCount)
+	setString("SNIPPETS_DIR", &c.SnippetsDir)
+	setString("TEXTS_DIR", &c.TextsDir)
+	setString("DEFAULT_LANGUAGE", &c.DefaultLanguage)
+	setInt("CUSTOM_TEXT_MAX_CHARS", &c.CustomTextMaxChars)
+	setInt("SEND_BUFFER_SIZE", &c.SendBufferSize)
+	setInt("SLOW_CLIENT_MAX_DROPPED", &c.SlowClientMaxDropped)
+	setDuration("PROGRESS_TICK", &c.ProgressTick)
+	setString("DATA_DIR", &c.DataDir)
+	setDuration("SNAPSHOT_INTERVAL", &c.SnapshotInterval)
+	setDuration("SESSION_RESUME_TIMEOUT", &c.SessionResumeTimeout)
+	setDuration("SHUTDOWN_TIMEOUT", &c.ShutdownTimeout)
+	setDuration("RECONNECT_DELAY", &c.ReconnectDelay)
+	if value, ok := os.LookupEnv("ALLOWED_ORIGINS"); ok {
+		c.AllowedOrigins = splitList(value)
+	}
+	setInt("MAX_CONNECTIONS_PER_IP", &c.MaxConnectionsPerIP)
+	setInt("MAX_PROTOCOL_HEADER_BYTES", &c.MaxProtocolHeaderBytes)
+	setBool("TRUST_PROXY_HEADERS", &c.TrustProxyHeaders)
+	if value, ok := os.LookupEnv("AUTH_REQUIRED_ROOMS"); ok {
+		for _, room := range splitList(value) {
+			if c.Rooms[room] == nil {
+				c.Rooms[room] = &RoomSettings{}
+			}
+			c.Rooms[room].RequireAuth = true
+		}
+	}
+	setInt("THROTTLE_WARN_AFTER", &c.ThrottleWarnAfter)
+	setInt("THROTTLE_DISCONNECT_AFTER", &c.ThrottleDisconnectAfter)
+	setString("ADMIN_TOKEN", &c.AdminToken)
+	setDuration("TOURNAMENT_JOIN_WINDOW", &c.TournamentJoinWindow)
+	setDuration("TOURNAMENT_RACE_BREAK", &c.TournamentRaceBreak)
+	setString("BROKER", &c.Broker)
+	setString("REDIS_URL", &c.RedisURL)
+	setString("NODE_ID", &c.NodeID)
+	setDuration("ROOM_OWNERSHIP_TTL", &c.RoomOwnershipTTL)
+	setString("LOG_LEVEL", &c.LogLevel)
+	setString("LOG_FORMAT", &c.LogFormat)
+	setUint("LOG_SAMPLE_EVERY", &c.LogSampleEvery)
+	return errors.Join(errs...)
+}
+
+// Validate reports every value that the server cannot run with.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Port < 1 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port %d is out of range", c.Port))
+	}
+	if parsed, err := url.Parse(c.APIURL); c.APIURL != "" && (err != nil || parsed.Scheme == "" || parsed.Host == "") {
+		errs = append(errs, fmt.Errorf("apiURL %q is not an absolute URL", c.APIURL))
+	}
+	if c.StartDelay < 0 {
+		errs = append(errs, errors.New("startDelay must not be negative"))
+	}
+	if c.AutoStartDelay <= 0 {
+		errs = append(errs, errors.New("autoStartDelay must be positive"))
+	}
+	if c.EndTimer <= 0 {
+		errs = append(errs, errors.New("endTimer must be positive"))
+	}
+	if c.WordCount < 1 {
+		errs = append(errs, errors.New("wordCount must be at least 1"))
+	}
+	for code, lang := range c.Languages {
+		if err := lang.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("languages.%s: %w", code, err))
+		}
+	}
+	if _, ok := c.Languages[c.DefaultLanguage]; !ok {
+		errs = append(errs, fmt.Errorf("defaultLanguage %q is not in languages", c.DefaultLanguage))
+	}
+	if c.CustomTextMaxChars < 1 {
+		errs = append(errs, errors.New("customTextMaxChars must be at least 1"))
+	}
+	if c.SendBufferSize < 1 {
+		errs = append(errs, errors.New("sendBufferSize must be at least 1"))
+	}
+	if c.SlowClientMaxDropped < 0 {
+		errs = append(errs, errors.New("slowClientMaxDropped must not be negative"))
+	}
+	if c.ProgressTick < 0 {
+		errs = append(errs, errors.New("progressTick must not be negative"))
+	}
+	if c.SnapshotInterval < 0 {
+		errs = append(errs, errors.New("snapshotInterval must not be negative"))
+	}
+	if c.SessionResumeTimeout <= 0 {
+		errs = append(errs, errors.New("sessionResumeTimeout must be positive"))
+	}
+	if c.ShutdownTimeout < 0 {
+		errs = append(errs, errors.New("shutdownTimeout must not be negative"))
+	}
+	if c.ReconnectDelay < 0 {
+		errs = append(errs, errors.New("reconnectDelay must not be negative"))
+	}
+	for _, origin := range c.AllowedOrigins {
+		if _, err := parseOriginPattern(origin); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.MaxConnectionsPerIP < 0 {
+		errs = append(errs, errors.New("maxConnectionsPerIP must not be negative"))
+	}
+	if c.MaxProtocolHeaderBytes < 1 {
+		errs = append(errs, errors.New("maxProtocolHeaderBytes must be at least 1"))
+	}
+	if _, ok := c.RateLimits[defaultRateLimit]; !ok {
+		errs = append(errs, errors.New("rateLimits needs a default entry"))
+	}
+	for messageType, limit := range c.RateLimits {
+		if limit.Rate <= 0 || limit.Burst < 1 {
+			errs = append(errs, fmt.Errorf("rateLimits.%s needs a positive rate and a burst of at least 1", messageType))
+		}
+	}
+	if c.ThrottleWarnAfter < 1 {
+		errs = append(errs, errors.New("throttleWarnAfter must be at least 1"))
+	}
+	if c.ThrottleDisconnectAfter < 0 {
+		errs = append(errs, errors.New("throttleDisconnectAfter must not be negative"))
+	}
+	if c.TournamentJoinWindow <= 0 || c.TournamentRaceBreak <= 0 {
+		errs = append(errs, errors.New("tournamentJoinWindow and tournamentRaceBreak must be positive"))
+	}
+	for room, settings := range c.Rooms {
+		if err := settings.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("rooms.%s: %w", room, err))
+		}
+		if _, ok := c.Languages[settings.Language]; settings.Language != "" && !ok {
+			errs = append(errs, fmt.Errorf("rooms.%s: language %q is not in languages", room, settings.Language))
+		}
+	}
+	if c.Broker != "memory" && c.Broker != "redis" {
+		errs = append(errs, fmt.Errorf("broker %q must be memory or redis", c.Broker))
+	}
+	if c.NodeID == "" {
+		errs = append(errs, errors.New("nodeID must not be empty"))
+	}
+	if c.RoomOwnershipTTL < time.Second {
+		errs = append(errs, errors.New("roomOwnershipTTL must be at least 1s"))
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		errs = append(errs, fmt.Errorf("logLevel %q is not a level", c.LogLevel))
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		errs = append(errs, fmt.Errorf("logFormat %q must be text or json", c.LogFormat))
+	}
+	if c.LogSampleEvery < 1 {
+		errs = append(errs, errors.New("logSampleEvery must be at least 1"))
+	}
+	return errors.Join(errs...)
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "node"
+	}
+	return name
+}
+
+// splitList splits a comma separated value and drops empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
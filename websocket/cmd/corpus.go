@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/exp/rand"
+)
+
+// sourceCorpus is the prefix of the sources that race on a saved corpus,
+// corpus:<name>.
+const sourceCorpus = "corpus:"
+
+var corpusNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// corpusStore keeps the named collections of texts hosts saved, one text per
+// line in DataDir/corpora/<name>.txt. Without a data directory nothing is
+// kept.
+type corpusStore struct {
+	mutex sync.Mutex
+	dir   string
+}
+
+func newCorpusStore(dataDir string) *corpusStore {
+	if dataDir == "" {
+		return &corpusStore{}
+	}
+	return &corpusStore{dir: filepath.Join(dataDir, "corpora")}
+}
+
+func validCorpusName(name string) error {
+	if !corpusNamePattern.MatchString(name) {
+		return fmt.Errorf("corpus name %q must be up to 32 lowercase letters, digits, - or _", name)
+	}
+	return nil
+}
+
+// Save appends a normalized text, which never contains a line break, to the
+// corpus name.
+func (store *corpusStore) Save(name string, text string) error {
+	if err := validCorpusName(name); err != nil {
+		return err
+	}
+	if store.dir == "" {
+		return nil
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := os.MkdirAll(store.dir, 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filepath.Join(store.dir, name+".txt"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(text + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Texts returns the texts saved to the corpus name, oldest first.
+func (store *corpusStore) Texts(name string) ([]string, error) {
+	if err := validCorpusName(name); err != nil {
+		return nil, err
+	}
+	if store.dir == "" {
+		return nil, nil
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	file, err := os.Open(filepath.Join(store.dir, name+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var texts []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			texts = append(texts, line)
+		}
+	}
+	return texts, scanner.Err()
+}
+
+// corpusSource returns the corpus name of a corpus:<name> source.
+func corpusSource(source string) (string, bool) {
+	return strings.CutPrefix(source, sourceCorpus)
+}
+
+// savedText picks one of the texts saved to the corpus name for a race in
+// lang. The texts were normalized when they were submitted.
+func (gs *GameServer) savedText(lang *Language, name string, rng *rand.Rand) (string, []string, error) {
+	texts, err := gs.corpora.Texts(name)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(texts) == 0 {
+		return "", nil, fmt.Errorf("corpus %q has no texts", name)
+	}
+	text := texts[rng.Intn(len(texts))]
+	return text, lang.splitWords(text), nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// normalizeText prepares submitted text for a race: it is brought into NFC
+// so that visually equal words compare equal, control characters are
+// dropped and every run of whitespace becomes a single space. Format
+// characters like the zero width non-joiner are kept, Persian needs them.
+func normalizeText(text string) string {
+	text = norm.NFC.String(strings.ToValidUTF8(text, ""))
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && !unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, text)
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// submitCustomText lets the host of a room choose the text of the next race:
+// {"text": "...", "corpus": "docs"}. With a corpus name the text is also
+// saved to that corpus.
+func (gs *GameServer) submitCustomText(client *Client, messageContent json.RawMessage) {
+	if client.room == "" {
+		gs.sendError(client, "join a room before submitting a text")
+		return
+	}
+	if gs.isHeatRoom(client.room) {
+		gs.sendError(client, "tournament heats race on generated texts")
+		return
+	}
+	gs.mutex.Lock()
+	host := gs.roomHostLocked(client.room) == client
+	gs.mutex.Unlock()
+	if !host {
+		gs.sendError(client, "only the host of the room can submit a text")
+		return
+	}
+	var options struct {
+		Text   string `json:"text"`
+		Corpus string `json:"corpus"`
+	}
+	if err := json.Unmarshal(messageContent, &options); err != nil {
+		gs.sendError(client, "invalid custom text")
+		return
+	}
+	// Code rooms keep line breaks and indentation and race line by line.
+	lang := gs.roomLanguage(client.room)
+	settings := gs.roomSettings(client.room)
+	custom := &raceText{language: lang.Code, mode: settings.mode()}
+	code := settings.mode() == modeCode
+	text := normalizeText(options.Text)
+	if code {
+		if options.Corpus != "" {
+			gs.sendError(client, "code can't be saved to a corpus")
+			return
+		}
+		custom.language = settings.CodeLanguage
+		if custom.language == "" {
+			custom.language = modeCode
+		}
+		text = normalizeCode(options.Text)
+	}
+	if text == "" {
+		gs.sendError(client, "the text is empty")
+		return
+	}
+	if length := utf8.RuneCountInString(text); length > gs.cfg.CustomTextMaxChars {
+		gs.sendError(client, fmt.Sprintf("the text has %d characters, at most %d are allowed", length, gs.cfg.CustomTextMaxChars))
+		return
+	}
+	if options.Corpus != "" {
+		if err := validCorpusName(options.Corpus); err != nil {
+			gs.sendError(client, err.Error())
+			return
+		}
+		if err := gs.corpora.Save(options.Corpus, text); err != nil {
+			gs.logFor(client).Error("saving custom text failed", "corpus", options.Corpus, "err", err)
+			gs.sendError(client, "saving the text failed")
+			return
+		}
+	}
+
+	custom.display, custom.words = text, lang.splitWords(text)
+	if code {
+		custom.words = codeLines(text)
+	}
+	gs.mutex.Lock()
+	gs.customTexts[client.room] = custom
+	gs.mutex.Unlock()
+	gs.logFor(client).Info("custom text submitted", "words", len(custom.words), "corpus", options.Corpus)
+
+	textMessage := struct {
+		Type   string `json:"type"`
+		Host   string `json:"host"`
+		Words  int    `json:"words"`
+		Corpus string `json:"corpus,omitempty"`
+	}{
+		Type:   "customText",
+		Host:   client.username,
+		Words:  len(custom.words),
+		Corpus: options.Corpus,
+	}
+	messageBytes, _ := json.Marshal(textMessage)
+	gs.broadcastToRoom(client.room, messageBytes)
+}
+
+// takeCustomText returns the text submitted for the next race of room, if
+// any, and forgets it.
+func (gs *GameServer) takeCustomText(room string) *raceText {
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+	custom := gs.customTexts[room]
+	delete(gs.customTexts, room)
+	return custom
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestNormalizeText(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"plain", "hello world", "hello world"},
+		{"whitespace runs", "  hello \t world\n", "hello world"},
+		{"line breaks", "one\r\ntwo\nthree", "one two three"},
+		{"no-break space", "a\u00a0b", "a b"},
+		{"only whitespace", "\t\n ", ""},
+		{"control characters", "a\u0000b\u0007c\u001b", "abc"},
+		{"invalid utf-8", "a\xffb", "ab"},
+		{"NFC", "cafe\u0301", "caf\u00e9"},
+		{"zwnj kept", "می\u200cروم", "می\u200cروم"},
+	}
+	for _, test := range tests {
+		if got := normalizeText(test.text); got != test.want {
+			t.Errorf("%s: normalizeText(%q) = %q, want %q", test.name, test.text, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/exp/rand"
+)
+
+// Difficulty makes generated texts harder than plain dictionary words.
+type Difficulty struct {
+	Punctuation    bool `yaml:"punctuation" json:"punctuation,omitempty"`       // commas and sentence endings
+	Capitalization bool `yaml:"capitalization" json:"capitalization,omitempty"` // capital letter at the start of every sentence
+	Numbers        bool `yaml:"numbers" json:"numbers,omitempty"`
+	Symbols        bool `yaml:"symbols" json:"symbols,omitempty"` // brackets, quotes and other symbols around words
+}
+
+// key names the difficulty in runs and match history, empty for plain
+// words. Only runs with the same key are compared with each other.
+func (d Difficulty) key() string {
+	var options []string
+	if d.Punctuation {
+		options = append(options, "punctuation")
+	}
+	if d.Capitalization {
+		options = append(options, "capitalization")
+	}
+	if d.Numbers {
+		options = append(options, "numbers")
+	}
+	if d.Symbols {
+		options = append(options, "symbols")
+	}
+	return strings.Join(options, "+")
+}
+
+const (
+	numberChance = 0.12
+	symbolChance = 0.1
+	commaChance  = 0.12
+	sentenceMin  = 4
+	sentenceMax  = 10
+)
+
+var symbolPatterns = []string{"(%s)", "[%s]", "{%s}", `"%s"`, "<%s>", "*%s*", "#%s", "@%s", "$%s", "%s%%"}
+
+// applyDifficulty turns plain words into the tokens of a race of the given
+// difficulty, drawing every choice from rng.
+func applyDifficulty(words []string, lang *Language, d Difficulty, rng *rand.Rand) []string {
+	result := make([]string, len(words))
+	copy(result, words)
+	for i := range result {
+		if d.Numbers && rng.Float64() < numberChance {
+			result[i] = lang.localDigits(strconv.Itoa(rng.Intn(10000)))
+		}
+		if d.Symbols && rng.Float64() < symbolChance {
+			result[i] = fmt.Sprintf(symbolPatterns[rng.Intn(len(symbolPatterns))], result[i])
+		}
+	}
+	if !d.Punctuation && !d.Capitalization {
+		return result
+	}
+
+	marks := lang.punctuation()
+	for start := 0; start < len(result); {
+		end := min(start+sentenceMin+rng.Intn(sentenceMax-sentenceMin+1), len(result))
+		if d.Capitalization {
+			result[start] = capitalize(result[start])
+		}
+		if d.Punctuation {
+			for i := start; i < end-1; i++ {
+				if rng.Float64() < commaChance {
+					result[i] += marks.Comma
+				}
+			}
+			result[end-1] += marks.Endings[rng.Intn(len(marks.Endings))]
+		}
+		start = end
+	}
+	return result
+}
+
+func capitalize(word string) string {
+	first, size := utf8.DecodeRuneInString(word)
+	return string(unicode.ToUpper(first)) + word[size:]
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// elimination is a series of races in an elimination room. After every race
+// the slowest players drop out and watch, until one player is left.
+type elimination struct {
+	round      int
+	remaining  map[string]*Client // by client id
+	eliminated []string           // usernames, in the order they dropped out
+	over       bool
+}
+
+// eliminationRound prepares game as the next round of the series the previous
+// race of room belonged to, or as the first round of a new series with every
+// player of the room. Players that are out are taken off the race.
+func (gs *GameServer) eliminationRound(room string, game *GameState, previous *GameState) {
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+	series := &elimination{remaining: make(map[string]*Client), eliminated: []string{}}
+	if previous != nil && previous.elimination != nil && !previous.elimination.over {
+		series = previous.elimination
+	} else {
+		for id, client := range game.InGameUsers {
+			series.remaining[id] = client
+		}
+	}
+	series.round++
+	for id := range game.InGameUsers {
+		if _, ok := series.remaining[id]; !ok {
+			delete(game.InGameUsers, id)
+		}
+	}
+	game.elimination = series
+}
+
+// announceRound tells the room who races in the current round and who
+// watches.
+func (gs *GameServer) announceRound(room string, game *GameState) {
+	gs.broadcastToRoom(room, gs.roundMessage(game))
+}
+
+func (gs *GameServer) roundMessage(game *GameState) []byte {
+	series := game.elimination
+	gs.mutex.Lock()
+	players := make([]string, 0, len(game.InGameUsers))
+	for _, client := range game.InGameUsers {
+		players = append(players, client.username)
+	}
+	gs.mutex.Unlock()
+	sort.Strings(players)
+	roundMessage := struct {
+		Type       string   `json:"type"`
+		MatchId    string   `json:"matchId"`
+		Round      int      `json:"round"`
+		Players    []string `json:"players"`
+		Eliminated []string `json:"eliminated"`
+	}{
+		Type:       "eliminationRound",
+		MatchId:    game.MatchId,
+		Round:      series.round,
+		Players:    players,
+		Eliminated: series.eliminated,
+	}
+	messageBytes, _ := json.Marshal(roundMessage)
+	return messageBytes
+}
+
+// racing reports whether client takes part in the current round. Everybody
+// races in the other modes.
+func (game *GameState) racing(client *Client) bool {
+	if game.elimination == nil {
+		return true
+	}
+	_, ok := game.elimination.remaining[client.id]
+	return ok
+}
+
+// eliminationRaceEnded is called by closeMatch. It ranks the players of the
+// round, knocks out the slowest ones and starts the next round after
+// AutoStartDelay, or declares the winner once one player is left.
+func (gs *GameServer) eliminationRaceEnded(room string, game *GameState, reason string) {
+	series := game.elimination
+	if series == nil || reason == "shutdown" || reason == "moved" {
+		return
+	}
+
+	gs.mutex.Lock()
+	eliminate := gs.roomSettingsLocked(room).eliminate()
+	var order, gone []*Client
+	ranked := make(map[string]bool)
+	for position := 1; position <= len(game.leaderBoard); position++ {
+		if client, ok := game.leaderBoard[strconv.Itoa(position)]; ok && series.remaining[client.id] != nil {
+			order = append(order, client)
+			ranked[client.id] = true
+		}
+	}
+	var unfinished []*Client
+	for id, client := range series.remaining {
+		switch {
+		case gs.rooms[room][id] == nil:
+			gone = append(gone, client)
+		case !ranked[id]:
+			unfinished = append(unfinished, client)
+		}
+	}
+	gs.mutex.Unlock()
+	remainingWords := func(client *Client) int {
+		if record, ok := game.PlayerProgress[client.id]; ok {
+			return len(record.remainedWords)
+		}
+		return game.TotalWords
+	}
+	sort.Slice(unfinished, func(i, j int) bool {
+		_, failedA := game.failed[unfinished[i].id]
+		_, failedB := game.failed[unfinished[j].id]
+		if failedA != failedB {
+			return failedB
+		}
+		a, b := remainingWords(unfinished[i]), remainingWords(unfinished[j])
+		if a != b {
+			return a < b
+		}
+		return unfinished[i].username < unfinished[j].username
+	})
+	order = append(order, unfinished...)
+
+	out := gone
+	if cut := min(eliminate, len(order)-1); cut > 0 {
+		out = append(out, order[len(order)-cut:]...)
+	}
+	var eliminated []string
+	for _, client := range out {
+		delete(series.remaining, client.id)
+		eliminated = append(eliminated, client.username)
+	}
+	series.eliminated = append(series.eliminated, eliminated...)
+	remaining := make([]string, 0, len(series.remaining))
+	for _, client := range series.remaining {
+		remaining = append(remaining, client.username)
+	}
+	sort.Strings(remaining)
+
+	eliminationMessage := struct {
+		Type       string   `json:"type"`
+		Round      int      `json:"round"`
+		Eliminated []string `json:"eliminated"`
+		Remaining  []string `json:"remaining"`
+		Winner     string   `json:"winner,omitempty"`
+		NextRound  int64    `json:"nextRound,omitempty"` // unix ms
+	}{
+		Type:       "elimination",
+		Round:      series.round,
+		Eliminated: eliminated,
+		Remaining:  remaining,
+	}
+	if len(series.remaining) <= 1 {
+		series.over = true
+		if len(remaining) == 1 {
+			eliminationMessage.Winner = remaining[0]
+		}
+		gs.log.Info("elimination finished", "room", room, "rounds", series.round, "winner", eliminationMessage.Winner)
+	} else {
+		eliminationMessage.NextRound = time.Now().UTC().Add(gs.cfg.AutoStartDelay).UnixMilli()
+		gs.log.Info("elimination round ended", "room", room, "round", series.round, "eliminated", len(eliminated), "remaining", len(remaining))
+		go func() {
+			time.Sleep(gs.cfg.AutoStartDelay)
+			gs.mutex.Lock()
+			current := gs.games[room] == game
+			gs.mutex.Unlock()
+			if current {
+				gs.readyToStart(room)
+			}
+		}()
+	}
+	messageBytes, _ := json.Marshal(eliminationMessage)
+	gs.broadcastToRoom(room, messageBytes)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// wantsGhost reports whether a startGame message asked for a ghost opponent.
+func wantsGhost(content json.RawMessage) bool {
+	var options struct {
+		Ghost bool `json:"ghost"`
+	}
+	if len(content) == 0 {
+		return false
+	}
+	if err := json.Unmarshal(content, &options); err != nil {
+		return false
+	}
+	return options.Ghost
+}
+
+// startGhostRace starts a race for a player that is alone in its room using
+// the text of the player's best recorded run (or the best run of anybody when
+// the player has none) and replays that run as a ghost opponent.
+func (gs *GameServer) startGhostRace(client *Client) {
+	room := client.room
+	if gs.refuseWhileDraining(room) {
+		gs.sendError(client, "the server is shutting down")
+		return
+	}
+	gs.mutex.Lock()
+	solo := len(gs.rooms[room]) == 1
+	gs.mutex.Unlock()
+	if !solo {
+		gs.sendError(client, "ghost races are only available when you are alone in the room")
+		return
+	}
+	if game, exists := gs.games[room]; exists && game.IsActive {
+		gs.sendError(client, "a race is already running in this room")
+		return
+	}
+	if gs.roomSettings(room).mode() != modeClassic {
+		gs.sendError(client, "ghost races are only available in classic rooms")
+		return
+	}
+
+	language := gs.roomLanguage(room).Code
+	difficulty := gs.roomSettings(room).Difficulty
+	run := gs.runs.Best(client.username, language, difficulty.key())
+	if run == nil {
+		gs.sendError(client, "there is no recorded run to race against yet")
+		return
+	}
+
+	gs.startGameWithText(room, &raceText{language: run.Language, display: run.Text, words: run.Words, difficulty: difficulty})
+	go gs.runGhost(room, gs.games[room].MatchId, gs.games[room].StartTime, run)
+}
+
+// runGhost replays the recorded word timings of run as progress updates until
+// the ghost finishes or the match is over.
+func (gs *GameServer) runGhost(room string, matchId string, startTime int64, run *RaceRun) {
+	ghostName := "ghost:" + run.Username
+	total := len(run.Offsets)
+	for i, offset := range run.Offsets {
+		time.Sleep(time.Until(time.UnixMilli(startTime + offset)))
+
+		gs.mutex.Lock()
+		game, exists := gs.games[room]
+		running := exists && game.IsActive && game.MatchId == matchId
+		gs.mutex.Unlock()
+		if !running {
+			return
+		}
+
+		progress := int(math.Round(float64(i+1) / float64(total) * 100))
+		typed := utf8.RuneCountInString(strings.Join(run.Words[:i+1], " "))
+		gs.reportProgress(room, ghostName, progress, wordsPerMinute(typed, offset))
+	}
+}
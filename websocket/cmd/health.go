@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// protocolVersion is bumped whenever the messages exchanged over the socket
+// change in a way clients have to know about.
+const protocolVersion = 3
+
+// Set at build time with -ldflags "-X main.version=... -X main.commit=... -X main.buildTime=...".
+var (
+	version   = "dev"
+	commit    = ""
+	buildTime = ""
+)
+
+// HandleHealth answers as long as the process is able to serve HTTP.
+func (gs *GameServer) HandleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// HandleReady reports whether the server should get traffic: the Run loop
+// answers, the auth API is reachable when one is configured, the data
+// directory is writable and no shutdown is in progress.
+func (gs *GameServer) HandleReady(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	checks := map[string]string{
+		"run":   checkResult(gs.checkRunLoop(ctx)),
+		"auth":  checkResult(gs.checkAuth(ctx)),
+		"store": checkResult(gs.runs.Writable()),
+	}
+	if gs.draining.Load() {
+		checks["shutdown"] = "draining"
+	}
+	status := http.StatusOK
+	for _, result := range checks {
+		if result != "ok" && result != "not configured" {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	statusText := "ok"
+	if status != http.StatusOK {
+		statusText = "unavailable"
+	}
+	writeJSON(w, status, map[string]any{"status": statusText, "checks": checks})
+}
+
+// HandleVersion reports what is running.
+func HandleVersion(w http.ResponseWriter, r *http.Request) {
+	revision := commit
+	if info, ok := debug.ReadBuildInfo(); ok && revision == "" {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				revision = setting.Value
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"version":         version,
+		"commit":          revision,
+		"buildTime":       buildTime,
+		"protocolVersion": protocolVersion,
+		"goVersion":       runtime.Version(),
+	})
+}
+
+var errNotConfigured = errors.New("not configured")
+
+func checkResult(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return "ok"
+}
+
+func (gs *GameServer) checkRunLoop(ctx context.Context) error {
+	reply := make(chan struct{})
+	select {
+	case gs.probe <- reply:
+	case <-ctx.Done():
+		return errors.New("run loop not responding")
+	}
+	<-reply
+	return nil
+}
+
+func (gs *GameServer) checkAuth(ctx context.Context) error {
+	if gs.cfg.APIURL == "" {
+		return errNotConfigured
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, gs.cfg.APIURL, nil)
+	if err != nil {
+		return err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return errors.New("auth API unreachable")
+	}
+	response.Body.Close()
+	if response.StatusCode >= http.StatusInternalServerError {
+		return errors.New("auth API returned " + response.Status)
+	}
+	return nil
+}
+
+// Writable checks that new runs and match results can be saved.
+func (rs *RunStore) Writable() error {
+	if rs.dir == "" {
+		return nil
+	}
+	file, err := os.CreateTemp(rs.dir, ".ready-*")
+	if err != nil {
+		return errors.New("data directory not writable")
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
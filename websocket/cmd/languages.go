@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// Text directions.
+const (
+	directionLTR = "ltr"
+	directionRTL = "rtl"
+)
+
+// Word splitting rules.
+const (
+	splitSpaces     = "spaces"     // words are separated by whitespace
+	splitCharacters = "characters" // every character is a word, for scripts written without spaces
+)
+
+// Language is a language rooms can race in. Languages are configured by
+// code; adding one only takes an entry in the config and its data files.
+type Language struct {
+	Code string `yaml:"-"`
+	Name string `yaml:"name"`
+	// Direction is ltr (the default) or rtl, clients lay the text out by it.
+	Direction string `yaml:"direction"`
+	// Split is how texts are split into words, spaces by default.
+	Split string `yaml:"split"`
+	// Words is the file random-word races draw from, whitespace separated.
+	// TextsDir/<code>.words.txt when empty, the words of the corpus when
+	// that file is missing.
+	Words string `yaml:"words"`
+	// Corpus is the file of quote and markov races, one sentence per line.
+	// TextsDir/<code>.txt when empty.
+	Corpus string `yaml:"corpus"`
+	// Punctuation is what the punctuation difficulty adds, English marks
+	// when empty.
+	Punctuation   Punctuation       `yaml:"punctuation"`
+	Normalization TextNormalization `yaml:"normalization"`
+}
+
+type Punctuation struct {
+	Comma   string   `yaml:"comma"`
+	Endings []string `yaml:"endings"` // sentence endings, picked evenly
+}
+
+var englishPunctuation = Punctuation{Comma: ",", Endings: []string{".", ".", ".", "?", "!"}}
+
+func (lang *Language) validate() error {
+	if lang.Direction != "" && lang.Direction != directionLTR && lang.Direction != directionRTL {
+		return fmt.Errorf("direction must be %s or %s", directionLTR, directionRTL)
+	}
+	if lang.Split != "" && lang.Split != splitSpaces && lang.Split != splitCharacters {
+		return fmt.Errorf("split must be %s or %s", splitSpaces, splitCharacters)
+	}
+	return lang.Normalization.validate()
+}
+
+func (lang *Language) direction() string {
+	if lang.Direction == "" {
+		return directionLTR
+	}
+	return lang.Direction
+}
+
+func (lang *Language) punctuation() Punctuation {
+	if lang.Punctuation.Comma == "" || len(lang.Punctuation.Endings) == 0 {
+		return englishPunctuation
+	}
+	return lang.Punctuation
+}
+
+func (lang *Language) wordsPath(textsDir string) string {
+	if lang.Words != "" {
+		return lang.Words
+	}
+	return filepath.Join(textsDir, lang.Code+".words.txt")
+}
+
+func (lang *Language) corpusPath(textsDir string) string {
+	if lang.Corpus != "" {
+		return lang.Corpus
+	}
+	return filepath.Join(textsDir, lang.Code+".txt")
+}
+
+// splitWords splits a normalized text into the words players type.
+func (lang *Language) splitWords(text string) []string {
+	if lang.Split != splitCharacters {
+		return strings.Fields(text)
+	}
+	var words []string
+	for _, r := range text {
+		if !unicode.IsSpace(r) {
+			words = append(words, string(r))
+		}
+	}
+	return words
+}
+
+// joinWords is the text players see for words.
+func (lang *Language) joinWords(words []string) string {
+	if lang.Split == splitCharacters {
+		return strings.Join(words, "")
+	}
+	return strings.Join(words, " ")
+}
+
+// localDigits writes the ASCII digits of number in the digits the language
+// folds to.
+func (lang *Language) localDigits(number string) string {
+	zero, ok := digitZeros[lang.Normalization.Digits]
+	if !ok {
+		return number
+	}
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return zero + (r - '0')
+		}
+		return r
+	}, number)
+}
+
+// normalization is the normalization of the language code, nil for the
+// languages of code races.
+func (gs *GameServer) normalization(code string) *TextNormalization {
+	if lang, ok := gs.cfg.Languages[code]; ok {
+		return &lang.Normalization
+	}
+	return nil
+}
+
+// direction is the direction of the language code, ltr for the languages of
+// code races.
+func (gs *GameServer) direction(code string) string {
+	if lang, ok := gs.cfg.Languages[code]; ok {
+		return lang.direction()
+	}
+	return directionLTR
+}
+
+// roomLanguage is the language of the texts of room, the default language
+// unless its settings name one.
+func (gs *GameServer) roomLanguage(room string) *Language {
+	code := gs.roomSettings(room).Language
+	if lang, ok := gs.cfg.Languages[code]; ok {
+		return lang
+	}
+	return gs.cfg.Languages[gs.cfg.DefaultLanguage]
+}
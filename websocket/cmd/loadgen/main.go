@@ -0,0 +1,296 @@
+// Command loadgen opens many WebSocket clients against a running game server,
+// spreads them across rooms and races them at a realistic typing speed.
+//
+//	go run ./cmd/loadgen -url ws://127.0.0.1:9000/ws -clients 300 -rooms 30
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+type stats struct {
+	connected    atomic.Int64
+	failed       atomic.Int64
+	racesStarted atomic.Int64
+	racesEnded   atomic.Int64
+	sent         atomic.Int64
+	received     atomic.Int64
+	dropped      atomic.Int64
+	serverErrors atomic.Int64
+	closedEarly  atomic.Int64
+
+	mutex     sync.Mutex
+	latencies []time.Duration
+}
+
+func (s *stats) addLatency(d time.Duration) {
+	s.mutex.Lock()
+	s.latencies = append(s.latencies, d)
+	s.mutex.Unlock()
+}
+
+func (s *stats) percentile(p float64) time.Duration {
+	if len(s.latencies) == 0 {
+		return 0
+	}
+	index := int(float64(len(s.latencies)-1) * p)
+	return s.latencies[index]
+}
+
+type options struct {
+	url      string
+	room     string
+	wpm      float64
+	variance float64
+	deadline time.Time
+}
+
+// racer is one simulated player.
+type racer struct {
+	opts     options
+	stats    *stats
+	conn     *websocket.Conn
+	username string
+
+	writeMutex sync.Mutex
+	// pending holds the send time of every wordComplete that has not been
+	// answered by a userProgress or raceProgress for this player yet. acked
+	// counts the answered words of the current race.
+	pendingMutex sync.Mutex
+	pending      []time.Time
+	acked        int
+	words        int
+}
+
+func (r *racer) send(messageType string, content any) error {
+	message := map[string]any{"type": messageType}
+	if content != nil {
+		message["content"] = content
+	}
+	data, _ := json.Marshal(message)
+	r.writeMutex.Lock()
+	defer r.writeMutex.Unlock()
+	if err := r.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		return err
+	}
+	r.stats.sent.Add(1)
+	return nil
+}
+
+func (r *racer) run(done func()) {
+	defer done()
+	conn, _, err := websocket.DefaultDialer.Dial(r.opts.url, nil)
+	if err != nil {
+		r.stats.failed.Add(1)
+		return
+	}
+	r.stats.connected.Add(1)
+	r.conn = conn
+	defer conn.Close()
+
+	r.send("usercred", map[string]string{"username": r.username})
+	r.send("join", map[string]string{"room": r.opts.room, "nickname": r.username})
+	r.send("ready", nil)
+
+	// Every race types until it ends, the next one starts or the
+	// connection goes away.
+	var stop chan struct{}
+	stopRace := func() {
+		if stop != nil {
+			close(stop)
+			stop = nil
+		}
+	}
+	defer stopRace()
+	conn.SetReadDeadline(r.opts.deadline)
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if time.Now().Before(r.opts.deadline) {
+				r.stats.closedEarly.Add(1)
+			}
+			r.dropPending()
+			return
+		}
+		r.stats.received.Add(1)
+		var message struct {
+			Type       string   `json:"type"`
+			Userid     string   `json:"userid"`
+			Words      []string `json:"words"`
+			StartTime  int64    `json:"startTime"`
+			Percentage int      `json:"percentage"`
+			Players    []struct {
+				Userid     string `json:"userid"`
+				Percentage int    `json:"percentage"`
+			} `json:"players"`
+		}
+		if err := json.Unmarshal(data, &message); err != nil {
+			continue
+		}
+		switch message.Type {
+		case "startGame":
+			r.stats.racesStarted.Add(1)
+			r.pendingMutex.Lock()
+			r.acked, r.words = 0, len(message.Words)
+			r.pendingMutex.Unlock()
+			stopRace()
+			stop = make(chan struct{})
+			go r.race(message.Words, message.StartTime, stop)
+		case "userProgress":
+			if message.Userid == r.username {
+				r.answered(1)
+			}
+		case "raceProgress":
+			for _, player := range message.Players {
+				if player.Userid == r.username {
+					r.answeredUpTo(player.Percentage)
+				}
+			}
+		case "endGame":
+			r.stats.racesEnded.Add(1)
+			stopRace()
+			r.dropPending()
+			if time.Now().Before(r.opts.deadline) {
+				r.send("ready", nil)
+			}
+		case "error":
+			r.stats.serverErrors.Add(1)
+		}
+	}
+}
+
+func (r *racer) race(words []string, startTime int64, stop chan struct{}) {
+	select {
+	case <-time.After(time.Until(time.UnixMilli(startTime))):
+	case <-stop:
+		return
+	}
+	for _, word := range words {
+		chars := utf8.RuneCountInString(word) + 1
+		delay := float64(chars) / 5 / r.opts.wpm * float64(time.Minute)
+		delay *= max(0.25, 1+rand.NormFloat64()*r.opts.variance)
+		select {
+		case <-time.After(time.Duration(delay)):
+		case <-stop:
+			return
+		}
+		r.pendingMutex.Lock()
+		r.pending = append(r.pending, time.Now())
+		r.pendingMutex.Unlock()
+		if err := r.send("wordComplete", map[string]string{"word": word}); err != nil {
+			return
+		}
+	}
+}
+
+func (r *racer) answered(words int) {
+	r.pendingMutex.Lock()
+	defer r.pendingMutex.Unlock()
+	r.answeredLocked(words)
+}
+
+// answeredUpTo answers the words a raceProgress percentage accounts for.
+func (r *racer) answeredUpTo(percentage int) {
+	r.pendingMutex.Lock()
+	defer r.pendingMutex.Unlock()
+	done := int(math.Round(float64(percentage) * float64(r.words) / 100))
+	r.answeredLocked(done - r.acked)
+}
+
+func (r *racer) answeredLocked(words int) {
+	for ; words > 0 && len(r.pending) > 0; words-- {
+		r.stats.addLatency(time.Since(r.pending[0]))
+		r.pending = r.pending[1:]
+		r.acked++
+	}
+}
+
+func (r *racer) dropPending() {
+	r.pendingMutex.Lock()
+	defer r.pendingMutex.Unlock()
+	r.stats.dropped.Add(int64(len(r.pending)))
+	r.pending = nil
+}
+
+func main() {
+	url := flag.String("url", "ws://127.0.0.1:9000/ws", "WebSocket endpoint of the game server")
+	clients := flag.Int("clients", 100, "number of simulated players")
+	rooms := flag.Int("rooms", 10, "number of rooms the players are spread across")
+	prefix := flag.String("room-prefix", "load", "prefix of the generated room names")
+	wpm := flag.Float64("wpm", 70, "average typing speed of the simulated players")
+	variance := flag.Float64("variance", 0.2, "relative standard deviation of the per-word speed")
+	ramp := flag.Duration("ramp", 5*time.Second, "time over which the clients connect")
+	duration := flag.Duration("duration", time.Minute, "how long the test runs")
+	flag.Parse()
+
+	if *clients < 1 || *rooms < 1 || *wpm <= 0 {
+		fmt.Fprintln(os.Stderr, "clients, rooms and wpm must be positive")
+		os.Exit(2)
+	}
+
+	s := &stats{}
+	deadline := time.Now().Add(*duration)
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+
+	var wg sync.WaitGroup
+	started := time.Now()
+	for i := 0; i < *clients; i++ {
+		r := &racer{
+			opts: options{
+				url:      *url,
+				room:     fmt.Sprintf("%s%d", *prefix, i%*rooms),
+				wpm:      *wpm,
+				variance: *variance,
+				deadline: deadline,
+			},
+			stats:    s,
+			username: fmt.Sprintf("load_%d", i),
+		}
+		wg.Add(1)
+		go r.run(wg.Done)
+		select {
+		case <-time.After(*ramp / time.Duration(*clients)):
+		case <-interrupt:
+			log.Println("interrupted while connecting")
+			i = *clients
+		}
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-interrupt:
+		log.Println("interrupted, reporting partial results")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+	fmt.Printf("elapsed            %v\n", time.Since(started).Round(time.Millisecond))
+	fmt.Printf("connections        %d ok, %d failed, %d closed early\n", s.connected.Load(), s.failed.Load(), s.closedEarly.Load())
+	fmt.Printf("races              %d started, %d ended\n", s.racesStarted.Load(), s.racesEnded.Load())
+	fmt.Printf("messages           %d sent, %d received\n", s.sent.Load(), s.received.Load())
+	fmt.Printf("progress latency   p50 %v  p90 %v  p99 %v  max %v  (%d samples)\n",
+		s.percentile(0.5), s.percentile(0.9), s.percentile(0.99), s.percentile(1), len(s.latencies))
+	fmt.Printf("dropped messages   %d\n", s.dropped.Load())
+	fmt.Printf("server errors      %d\n", s.serverErrors.Load())
+}
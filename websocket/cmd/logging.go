@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// newLogger builds the process logger. level is one of debug, info, warn or
+// error and format is json or text.
+func newLogger(w io.Writer, level string, format string) *slog.Logger {
+	var parsed slog.Level
+	if err := parsed.UnmarshalText([]byte(level)); err != nil {
+		parsed = slog.LevelInfo
+	}
+	options := &slog.HandlerOptions{Level: parsed}
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(w, options))
+	}
+	return slog.New(slog.NewTextHandler(w, options))
+}
+
+// samplingHandler passes on only every n-th record of each message, so events
+// like wordComplete and userProgress don't drown everything else.
+type samplingHandler struct {
+	slog.Handler
+	every    uint64
+	counters *sync.Map // message -> *atomic.Uint64
+}
+
+func newSamplingHandler(handler slog.Handler, every uint64) *samplingHandler {
+	return &samplingHandler{Handler: handler, every: every, counters: &sync.Map{}}
+}
+
+func (h *samplingHandler) Handle(ctx context.Context, record slog.Record) error {
+	counter, _ := h.counters.LoadOrStore(record.Message, &atomic.Uint64{})
+	if (counter.(*atomic.Uint64).Add(1)-1)%h.every != 0 {
+		return nil
+	}
+	if h.every > 1 {
+		record.AddAttrs(slog.Uint64("sampled", h.every))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *samplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &samplingHandler{Handler: h.Handler.WithAttrs(attrs), every: h.every, counters: h.counters}
+}
+
+func (h *samplingHandler) WithGroup(name string) slog.Handler {
+	return &samplingHandler{Handler: h.Handler.WithGroup(name), every: h.every, counters: h.counters}
+}
+
+// clientAttrs are the fields every log line about client carries.
+func (gs *GameServer) clientAttrs(client *Client) []any {
+	attrs := []any{"client", client.id, "user", client.username}
+	if client.room != "" {
+		attrs = append(attrs, "room", client.room)
+		if game, exists := gs.games[client.room]; exists && game.IsActive {
+			attrs = append(attrs, "match", game.MatchId)
+		}
+	}
+	return attrs
+}
+
+// logFor returns the logger for events caused by client.
+func (gs *GameServer) logFor(client *Client) *slog.Logger {
+	return gs.log.With(gs.clientAttrs(client)...)
+}
+
+// hotLogFor returns the sampled logger for per-word events of client.
+func (gs *GameServer) hotLogFor(client *Client) *slog.Logger {
+	return gs.hotLog.With(gs.clientAttrs(client)...)
+}
@@ -2,706 +2,897 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"math"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golang.org/x/exp/rand"
 )
 
-
 type Client struct {
-	conn *websocket.Conn
-	id string
+	conn     *websocket.Conn
+	id       string
+	session  string // survives reconnects, see resumeSession
 	username string
-	room string
-	sendChan chan []byte
-	isReady bool
+	room     string
+	outbox   *outbox
+	isReady  bool
+	isBot    bool
+	// authenticated is set for clients whose auth token was verified.
+	authenticated bool
+	ip            string
+	limiter       *rateLimiter // nil for bots
+	// ownerNode is set while the client plays in a room run by another
+	// node, homeNode for remote clients whose socket is on another node.
+	ownerNode string
+	homeNode  string
 }
 
 type GameServer struct {
-	clients map[string]*Client
-	rooms map[string]map[string]*Client
-	games map[string]*GameState
-	register chan *Client
-	unregister chan *Client
-	mutex sync.Mutex
-	upgrader websocket.Upgrader
-	apiURL string
+	clients       map[string]*Client
+	connections   map[*Client]struct{} // every open socket, joined a room or not
+	ipConnections map[string]int
+	settings      map[string]*RoomSettings
+	rooms         map[string]map[string]*Client
+	games         map[string]*GameState
+	register      chan *Client
+	unregister    chan *Client
+	probe         chan chan struct{} // answered by Run, used by the readiness check
+	mutex         sync.Mutex
+	upgrader      websocket.Upgrader
+	cfg           *Config
+	runs          *RunStore
+	log           *slog.Logger
+	hotLog        *slog.Logger // sampled, for per-word and per-message events
+	draining      atomic.Bool  // set once shutdown started, new connections and races are refused
+	snapshotMutex sync.Mutex   // one SaveSnapshot at a time, they share the temp file
+	broker        Broker
+	owned         map[string]bool    // rooms this node claimed
+	remotes       map[string]*Client // clients of other nodes playing here
+	forwarded     map[string]*Client // local clients playing on other nodes
+	detached      map[string]*Client // restored players by session, waiting to be resumed
+	tournaments   *tournaments
+	hosts         map[string]string    // client id of the host by room, see roomHostLocked
+	customTexts   map[string]*raceText // text of the next race by room
+	corpora       *corpusStore
+	texts         map[string]*languageTexts // corpus and model by language, see LoadTexts
+	// tournamentWatchers are the clients that asked for updates of a tournament.
+	tournamentWatchers map[string]map[*Client]bool
 }
 
 type PlayerWordRecord struct {
-	username        string           
-	remainedWords   []string	
+	username      string
+	remainedWords []string
+	wordTimes     []int64 // ms after StartTime at which each word was completed
 }
 
 type GameState struct {
-	Text            string            `json:"text"`
-	StartTime       int64         `json:"startTime"`
-	IsActive        bool              `json:"isActive"`
-	PlayerProgress  map[string]*PlayerWordRecord    `json:"playerProgress"` // tracks words completed by each player
-	leaderBoard     map[string]*Client          `json:"leaderBoard,omitempty"`
-	TotalWords      int              `json:"totalWords"`
-	InGameUsers      map[string]*Client        `json:"inGameUsers"`
-	wordList       []string         `json:"wordList"`
-	language        string          `json:"language"`
-	MatchId         string           `json:"matchId"`
+	Text           string                       `json:"text"`
+	StartTime      int64                        `json:"startTime"`
+	IsActive       bool                         `json:"isActive"`
+	PlayerProgress map[string]*PlayerWordRecord `json:"playerProgress"` // tracks words completed by each player
+	leaderBoard    map[string]*Client
+	TotalWords     int                `json:"totalWords"`
+	InGameUsers    map[string]*Client `json:"inGameUsers"`
+	wordList       []string
+	language       string
+	MatchId        string `json:"matchId"`
+	// progress collects the latest progress per player for the next
+	// raceProgress snapshot; guarded by gs.mutex.
+	progress        map[string]*racerProgress
+	progressChanged bool
+	mode            string
+	relay           *relayState  // set for relay races
+	elimination     *elimination // set for the rounds of an elimination
+	suddenDeath     bool
+	// failed maps the players out of a sudden death race to the order in
+	// which they typed a wrong word.
+	failed     map[string]int
+	difficulty Difficulty
+	seed       uint64 // the text was generated from, 0 for texts that were not
+	// normalization of the language, words match when their matchKey does.
+	normalization *TextNormalization
+	// teamProgress are the relay team standings for the next tick, nil when
+	// nothing changed since the last teamProgress message; guarded by
+	// gs.mutex.
+	teamProgress []*teamStanding
 }
 
 type GameMessage struct {
-	Type            string           `json:"type"`
+	Type string `json:"type"`
 }
 
 type roomStatus struct {
-	Type            string           `json:"type"`
-	Players         map[string]bool
+	Type    string `json:"type"`
+	Players map[string]bool
 }
 
-
-func NewGameServer(apiURL string) *GameServer {
+func NewGameServer(cfg *Config, runs *RunStore, broker Broker, logger *slog.Logger) *GameServer {
 	rooms := make(map[string]map[string]*Client)
-	rooms["room1"] = make(map[string]*Client)
-	rooms["room2"] = make(map[string]*Client)
-	rooms["room3"] = make(map[string]*Client)
-	return &GameServer{
-		clients: make(map[string]*Client),
-		rooms: rooms,
-		games: make(map[string]*GameState),
-		register: make(chan *Client),
-		unregister: make(chan *Client),
-		apiURL: apiURL,
+	settings := make(map[string]*RoomSettings)
+	for room, roomSettings := range cfg.Rooms {
+		rooms[room] = make(map[string]*Client)
+		copied := *roomSettings
+		settings[room] = &copied
+	}
+	gs := &GameServer{
+		clients:            make(map[string]*Client),
+		connections:        make(map[*Client]struct{}),
+		ipConnections:      make(map[string]int),
+		settings:           settings,
+		rooms:              rooms,
+		games:              make(map[string]*GameState),
+		register:           make(chan *Client),
+		unregister:         make(chan *Client),
+		probe:              make(chan chan struct{}),
+		cfg:                cfg,
+		runs:               runs,
+		log:                logger,
+		hotLog:             slog.New(newSamplingHandler(logger.Handler(), cfg.LogSampleEvery)),
+		broker:             broker,
+		owned:              make(map[string]bool),
+		remotes:            make(map[string]*Client),
+		forwarded:          make(map[string]*Client),
+		detached:           make(map[string]*Client),
+		tournaments:        newTournaments(cfg.DataDir),
+		tournamentWatchers: make(map[string]map[*Client]bool),
+		hosts:              make(map[string]string),
+		customTexts:        make(map[string]*raceText),
+		corpora:            newCorpusStore(cfg.DataDir),
 		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool	{
-				return true
-			},
 			Subprotocols: []string{"auth_token", "nickname"},
 		},
 	}
+	gs.upgrader.CheckOrigin = gs.checkOrigin
+	return gs
 }
 
-func (gs *GameServer) userRanking(client *Client){
+func (gs *GameServer) userRanking(client *Client) {
 	playerPosition := len(gs.games[client.room].leaderBoard) + 1
 	gs.games[client.room].leaderBoard[strconv.Itoa(playerPosition)] = client
 
 	player := make(map[string]int)
 	player[client.id] = playerPosition
 	playerRank := struct {
-		Type     string           `json:"type"`
-		PlayerRank  map[string]int  `json:"playerrank"`
+		Type       string         `json:"type"`
+		PlayerRank map[string]int `json:"playerrank"`
 	}{
-		Type: "playerRank",
+		Type:       "playerRank",
 		PlayerRank: player,
 	}
-			
+
 	messageBytes, _ := json.Marshal(playerRank)
-	gs.broadcastToRoom(client.room , messageBytes)
+	gs.broadcastToRoom(client.room, messageBytes)
 
 }
 
-func generateCompetitionText(room string) (string, []string ) {
-	// Common Persian words for room3
-	persianWords := []string{
-		"سلام", "جهان", "کتاب", "خانه", "درخت", "آزادی", "عشق", "دوست", "خورشید", "ماه",
-		"ستاره", "آسمان", "زمین", "رود", "کوه", "گل", "پرنده", "باغ", "شهر", "روستا",
-	}
-
-	// English words for other rooms
-	englishWords := []string{
-		"introductory", "preliminary", "ceremonial", "demonstrating", "graves", "speed", "hoses", "steep", "reunions", "I", "You", "Yeah",
-		"script", "devoted", "prepositions", "indie", "fascinating", "courage", "Star", "Five", "outside",
-	}
+// raceText is the text a race is run on.
+type raceText struct {
+	language   string
+	display    string
+	words      []string
+	difficulty Difficulty // what generated texts were made with
+	seed       uint64
+	mode       string // the room mode a custom text was split for
+}
 
-	var words []string
+// generateCompetitionText draws wordCount words of lang from words and makes
+// them as hard as difficulty asks.
+func generateCompetitionText(lang *Language, words []string, wordCount int, difficulty Difficulty, rng *rand.Rand) (string, []string) {
 	var result []string
-	var displayText string
-
-	if room == "room3" {
-		words = persianWords
-	} else {
-		words = englishWords
-	}
-
-	// Check if the word list is empty
-	if len(words) == 0 {
-		fmt.Println("ohhhahahahahha")
-	}
-
-	// Generate 10 random words
-	for i := 0; i < 10; i++ {
-		word := words[rand.Intn(len(words))]
+	for i := 0; i < wordCount; i++ {
+		word := words[rng.Intn(len(words))]
 		result = append(result, word)
 	}
-
-	if room == "room3" {
-		// Join without spaces for Persian
-		displayText = strings.Join(result, " ")
-	} else {
-		// Join with spaces for English
-		displayText = strings.Join(result, " ")
-	}
-
-	return displayText, result
+	result = applyDifficulty(result, lang, difficulty, rng)
+	return lang.joinWords(result), result
 }
 
 func (gs *GameServer) Run() {
-    for {
-        select {
-        case client := <-gs.register:
-			fmt.Println("the gs register in Run is working and this is the client : ", client)
-            // Lock here
+	for {
+		select {
+		case client := <-gs.register:
+			gs.logFor(client).Debug("client registered")
+			gs.mutex.Lock()
+			gs.clients[client.id] = client
+			if _, exists := gs.rooms[client.room]; !exists {
+				gs.rooms[client.room] = make(map[string]*Client)
+			}
+			gs.rooms[client.room][client.id] = client
+			gs.mutex.Unlock()
+		case reply := <-gs.probe:
+			close(reply)
+		case client := <-gs.unregister:
+			gs.logFor(client).Debug("client unregistered")
 			gs.mutex.Lock()
-            gs.clients[client.id] = client
-            if _, exists := gs.rooms[client.room]; !exists {
-                gs.rooms[client.room] = make(map[string]*Client)
-            }
-            gs.rooms[client.room][client.id] = client
+			if _, ok := gs.clients[client.id]; ok {
+				delete(gs.rooms[client.room], client.id)
+				delete(gs.clients, client.id)
+				if game, exists := gs.games[client.room]; exists {
+					delete(game.InGameUsers, client.id)
+				}
+				client.outbox.close("")
+				gs.removeIdleBotsLocked(client.room)
+			}
 			gs.mutex.Unlock()
-			// Unlock here
-        case client := <-gs.unregister:
-            gs.mutex.Lock()
-			fmt.Println("On Unregistereeeeeeeeeeeeeeeeeeeeeeeeeeeeee&&*&*77777778")
-            if _, ok := gs.clients[client.id]; ok {
-                delete(gs.rooms[client.room], client.id)
-                delete(gs.clients, client.id)
-				delete(gs.games[client.room].InGameUsers , client.id)
-                close(client.sendChan)
-            }
-            gs.mutex.Unlock()
-        }
-    }
-}
-
-
-func (gs *GameServer) roomsStatus(client *Client){
+		}
+	}
+}
+
+func (gs *GameServer) roomsStatus(client *Client) {
 	roomsStatus := struct {
-		Type    string                         `json:"type"` 
-		Rooms   map[string]map[string]*Client  `json:"rooms"` 
-  
+		Type  string                        `json:"type"`
+		Rooms map[string]map[string]*Client `json:"rooms"`
 	}{
-		Type: "roomsStatus",
+		Type:  "roomsStatus",
 		Rooms: gs.rooms,
 	}
 
 	messageBytes, _ := json.Marshal(roomsStatus)
-	select{
-			case client.sendChan <- messageBytes:
-				fmt.Println("message goes to user")	
-			default:
-				close(client.sendChan)
-				delete(gs.clients, client.id)
-			}
-
+	gs.send(client, messageBytes)
 
 }
 
-func (gs *GameServer) roomStatus(client *Client){
-	
-	fmt.Println(client.username, "username hereeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee!!!@!@!@!@!@!@!@!@!@!@@!@!")
+func (gs *GameServer) roomStatus(client *Client) {
 	guests := make(map[string]bool)
-	for key , value := range gs.rooms[client.room]{
-		fmt.Println(key, "salam in key hasttttttttttttttttttttttttttttttttttttttttttttttttttttt")
+	for _, value := range gs.rooms[client.room] {
 		guests[value.username] = value.isReady
 	}
+	var host string
+	gs.mutex.Lock()
+	if hostClient := gs.roomHostLocked(client.room); hostClient != nil {
+		host = hostClient.username
+	}
+	gs.mutex.Unlock()
 	roomStatus := struct {
-		Type     string           `json:"type"`
-		Players  map[string]bool  `json:"players"`
+		Type     string          `json:"type"`
+		Players  map[string]bool `json:"players"`
+		Settings RoomSettings    `json:"settings"`
+		Host     string          `json:"host"`
 	}{
-		Type: "roomStatus",
-		Players: guests,
+		Type:     "roomStatus",
+		Players:  guests,
+		Settings: gs.roomSettings(client.room),
+		Host:     host,
 	}
-			
+
 	messageBytes, _ := json.Marshal(roomStatus)
-	if clients, ok := gs.rooms[client.room]; ok{
+	if clients, ok := gs.rooms[client.room]; ok {
 		for _, thatclient := range clients {
-			if _ , gameExist := gs.games[client.room]; gameExist {
-				fmt.Println(gs.games[client.room].InGameUsers ,  "***********************************************************************************************************************")
-				if _ , ok := gs.games[client.room].InGameUsers[thatclient.id]; !ok {
-					fmt.Println("where we want)()()()(((((((((((((((((((((((((((((((((()))))))))))))))))))))))))))))))))")
-					select{
-						case thatclient.sendChan <- messageBytes:
-							fmt.Println("message goes to user")	
-						default:
-							close(client.sendChan)
-							delete(gs.clients, client.id)
-						}
-
+			if _, gameExist := gs.games[client.room]; gameExist {
+				if _, ok := gs.games[client.room].InGameUsers[thatclient.id]; !ok {
+					gs.send(thatclient, messageBytes)
 				}
-			}else{
-					select{
-						case thatclient.sendChan <- messageBytes:
-							fmt.Println("message goes to user")	
-						default:
-							close(client.sendChan)
-							delete(gs.clients, client.id)
-						}
-					}	
-
+			} else {
+				gs.send(thatclient, messageBytes)
+			}
 
 		}
 	}
 
-
 }
 
-func (gs *GameServer) joinPlayer(client *Client, message json.RawMessage){
-	if len(gs.rooms[client.room]) == 0 {
-		go func() {
-			<-time.After(10 * time.Second)
-			gs.readyToStart(client.room)
-		}()
-	}
-	fmt.Println(client.username, "eyesssssssssssssssssssssssssssssss on thissssssssssssssssssssssssssssssssssssss")
+func (gs *GameServer) joinPlayer(client *Client, message json.RawMessage) {
 	var result map[string]string
-	err := json.Unmarshal(message , &result)
+	err := json.Unmarshal(message, &result)
 	if err != nil {
-		fmt.Println("Error while parsing message in join player")
+		gs.logFor(client).Warn("invalid join message", "err", err)
 	}
 	room := result["room"]
-	nickname := result["nickname"]
-	fmt.Println("THIS IS NICKNAME JAKKKKKKKKKKKKKKKKKKKKKKKKKKKKKKKKKKKKKKKKKKKKKKKKKKKKK#################### ", nickname)
-	fmt.Println(room , "here is the messeage !@")
+	if gs.requiresAuth(room) && !client.authenticated {
+		gs.sendError(client, "this room is only open to logged in players")
+		return
+	}
+	if !gs.canJoinHeat(room, client.username) {
+		gs.sendError(client, "this tournament heat is only open to its players")
+		return
+	}
+	if len(gs.rooms[room]) == 0 {
+		go func() {
+			<-time.After(gs.cfg.AutoStartDelay)
+			gs.readyToStart(room)
+		}()
+	}
 	client.room = room
 	gs.mutex.Lock()
 	gs.clients[client.id] = client
-    if _, exists := gs.rooms[client.room]; !exists {
-        gs.rooms[client.room] = make(map[string]*Client)
-    }
-    gs.rooms[client.room][client.id] = client
+	if _, exists := gs.rooms[client.room]; !exists {
+		gs.rooms[client.room] = make(map[string]*Client)
+	}
+	gs.rooms[client.room][client.id] = client
+	gs.roomHostLocked(client.room)
 	gs.mutex.Unlock()
-	_ , exist := gs.games[client.room]
-	if exist{
-		fmt.Println("ROOOOOOOOOOOMMMMMMMMMMMMMMMMMMMMMMMMMMMmm    EXISSSSSSSSSSSSSSSSSSSTTTTTTTTTT")
+	gs.logFor(client).Info("client joined room")
+	_, exist := gs.games[client.room]
+	if exist {
 		if gs.games[client.room].IsActive {
-			fmt.Println("ROOOOOOOOOOOOOOOOOOOOOOOOOOOOM IS ACCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCc!!!!!!!!!!!!!!!")
 			gs.joinRunningGame(client)
-			fmt.Println(gs.rooms[client.room], "IIIIIIIIIIIIIIIIIIIIIIIIIIIISSSSSSSSSSSSSSSS PLAYERSSSSSSSSSSSSSSSSSS(*#&#(&@&#(&#@@(()))))")
-		}else{
-			fmt.Println("ROOOOOOOOOOOOOOOOOOOOOOOOOOOOM IS NNNNNNNNOOOOOOOOOOOOOOTTTTTTTTTTTTT  ACCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCc!!!!!!!!!!!!!!!")
+		} else {
 			gs.roomStatus(client)
 		}
-	}else{
-		fmt.Println("ROOOOOOOOOOOOOOOOOOOOOOOOOOOOM IS DOWSNT EXITS AT ALLL") 
+	} else {
 		gs.roomStatus(client)
 	}
-	
-	fmt.Println("here is the player room" , client.room)
-	fmt.Println("important one ########################################" , gs.rooms[client.room])
-	
-	
 
 }
 
-
-
-func (gs *GameServer) readyPlayer(client *Client){
+func (gs *GameServer) readyPlayer(client *Client) {
 	client.isReady = true
 	gs.roomStatus(client)
-	for key, value := range gs.rooms[client.room] {
-		fmt.Println(key, "")
-        if value.isReady != true{
-			fmt.Println("player ", value.username, "with this id : ", key ," is not ready and game does not going to start")
+	gs.logFor(client).Debug("player ready")
+	for _, value := range gs.rooms[client.room] {
+		if !value.isReady {
 			return
-		}else{
-			fmt.Println(key , value.isReady , "yoooooooooo pretty motherfoucker gooooooooooooooooooooooooooooooo")
 		}
-		
-    } 
+	}
 	gs.readyToStart(client.room)
-	fmt.Println("AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAfter rommstatus", gs.games[client.room].InGameUsers)
 }
 
-
-func (gs *GameServer) endGame(client *Client, matchId string){
-	fmt.Println("end offfffffffffffffffffffffff gameeeeeeeeeeeeeeeeeeeee")
+func (gs *GameServer) endGame(client *Client, matchId string) {
 	for key, value := range gs.rooms[client.room] {
-		fmt.Println(value.isReady , "active brossss")
-		gs.rooms[client.room][key].isReady = false
-	}
-	if len(gs.games[client.room].leaderBoard) == len(gs.rooms[client.room]){
-			
-			endGameMessage := struct{
-				Type			  string				`json:"type"`
-			}{
-				Type: "endGame",
+		gs.rooms[client.room][key].isReady = value.isBot
+	}
+	if gs.everyoneDone(client.room, gs.games[client.room]) {
 
-			}
-		gs.games[client.room].IsActive = false
-		fmt.Println("game activity goes ***&*&&&&&&&&&&&&&&*&*&*&*&*&&**&*%*%*%*%*%*%#*^#(@%&#%*&%#&*%&#%&^#*#&%@^&#$*@^*(#!!!!!!!!!!!!!!!!!!!!!!!!!!!!!))", gs.games[client.room].IsActive )
+		endGameMessage := struct {
+			Type string `json:"type"`
+		}{
+			Type: "endGame",
+		}
+		gs.closeMatch(client.room, "finished")
 		messageBytes, _ := json.Marshal(endGameMessage)
-		gs.broadcastToRoom(client.room , messageBytes)
+		gs.broadcastToRoom(client.room, messageBytes)
 		return
 
+	}
+	go gs.runEndTimer(client.room, matchId)
 
+}
+
+// everyoneDone reports whether every racer of game finished or, in sudden
+// death, is out.
+func (gs *GameServer) everyoneDone(room string, game *GameState) bool {
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+	racers := len(gs.rooms[room])
+	if game.elimination != nil {
+		racers = len(game.InGameUsers)
 	}
-	go func() {
-        ticker := time.NewTicker(1 * time.Second)
-        timeRemaining := 20
-        
-        for range ticker.C {
-            timeRemaining--
-            
-            if timeRemaining <= 0 {
-				gs.games[client.room].IsActive = false
-                ticker.Stop()
-                
-                //gs.mutex.Lock()
-                //gs.inProgress = false
-                //gs.mutex.Unlock()
-				endGameMessage := &GameMessage{
-					Type: "endGame",
-				}
-				messageBytes, _ := json.Marshal(endGameMessage)
-				if gs.games[client.room].MatchId == matchId{
-					fmt.Println("room status is " , gs.games[client.room].IsActive)
-					fmt.Println("players are ", gs.games[client.room].InGameUsers)
-					for _ , playerInGame := range gs.games[client.room].InGameUsers {
-						select{
-							case playerInGame.sendChan <- messageBytes:
-								fmt.Println("message goes to user in the gameee")	
-							default:
-								close(playerInGame.sendChan)
-								delete(gs.clients, playerInGame.id)
-							}
-						
-						
-					}
+	return len(game.leaderBoard)+len(game.failed) >= racers
+}
+
+// runEndTimer ends the match of room with reason timeout once EndTimer
+// passed, unless it is over by then.
+func (gs *GameServer) runEndTimer(room string, matchId string) {
+	ticker := time.NewTicker(1 * time.Second)
+	timeRemaining := int(gs.cfg.EndTimer / time.Second)
+
+	for range ticker.C {
+		timeRemaining--
+
+		if timeRemaining <= 0 {
+			ticker.Stop()
+			game := gs.games[room]
+			if game.MatchId != matchId || !game.IsActive {
+				break
+			}
+			gs.closeMatch(room, "timeout")
+
+			endGameMessage := &GameMessage{
+				Type: "endGame",
+			}
+			messageBytes, _ := json.Marshal(endGameMessage)
+			for _, playerInGame := range game.InGameUsers {
+				gs.send(playerInGame, messageBytes)
+
 			}
 
-                
-                break
-            }
-            
-            // Optional: broadcast time updates (every second or at intervals)
-        }
-    }()	
+			break
+		}
 
+		// Optional: broadcast time updates (every second or at intervals)
+	}
 }
 
-func (gs *GameServer) userProgress(client *Client, progress int){
-	progressMessage := struct {
-		Type string      `json:"type"`
-		Userid string  `json:"userid"`
-		Percentage int   `json:"percentage"`
+func (gs *GameServer) userProgress(client *Client, progress int) {
+	game := gs.games[client.room]
+	record := game.PlayerProgress[client.id]
+	completed := game.TotalWords - len(record.remainedWords)
+	var wpm float64
+	if len(record.wordTimes) > 0 {
+		typed := utf8.RuneCountInString(strings.Join(game.wordList[:completed], " "))
+		wpm = wordsPerMinute(typed, record.wordTimes[len(record.wordTimes)-1])
+	}
+	gs.reportProgress(client.room, client.username, progress, wpm)
+}
 
+func (gs *GameServer) broadcastUserProgress(room string, userid string, progress int) {
+	progressMessage := struct {
+		Type       string `json:"type"`
+		Userid     string `json:"userid"`
+		Percentage int    `json:"percentage"`
 	}{
-		Type: "userProgress",
-		Userid: client.username,
+		Type:       "userProgress",
+		Userid:     userid,
 		Percentage: progress,
 	}
 	messageBytes, _ := json.Marshal(progressMessage)
-	gs.broadcastToRoom(client.room , messageBytes)
-
-
+	gs.hotLog.Debug("progress broadcast", "room", room, "user", userid, "percentage", progress)
+	gs.broadcastToRoom(room, messageBytes)
 
 }
 
-func (gs *GameServer) wordComplete(client *Client , messageContent json.RawMessage){
-	fmt.Println("a word Complete message comes in ! ( print in wordComplete method )")
+func (gs *GameServer) wordComplete(client *Client, messageContent json.RawMessage) {
 	var result map[string]string
 	err := json.Unmarshal(messageContent, &result)
-	if err != nil{
-		fmt.Println("error while parsing message in wordComplete method")
+	if err != nil {
+		gs.logFor(client).Warn("invalid wordComplete message", "err", err)
 	}
-	fmt.Println(result)
-	fmt.Println("WE ARE COLL99999999999999999999999999999999999")
 	userInputWord := result["word"]
-	fmt.Println("are we coll?88888888888888888888888888888888888")
-	fmt.Println(gs.games[client.room].PlayerProgress, "{}{{{{{{{{{PLAYER PROGRESSJk}}}}}}}}}")
+	game, exists := gs.games[client.room]
+	if !exists || game.mode == modeCode {
+		return
+	}
+	gs.completeWord(client, game, userInputWord)
+}
+
+// completeWord checks what client typed against its next word, or its next
+// line in code races, and moves the client on when it matches.
+func (gs *GameServer) completeWord(client *Client, game *GameState, userInputWord string) {
+	if game.relay != nil {
+		gs.relayWordComplete(client, game, userInputWord)
+		return
+	}
+	if _, failed := game.failed[client.id]; failed || !game.racing(client) {
+		return
+	}
 	userWordInGame := &gs.games[client.room].PlayerProgress[client.id].remainedWords
-	if userInputWord != (*userWordInGame)[0]{
-		fmt.Println(userWordInGame)
-		fmt.Println(userInputWord)
-		fmt.Println("CHEATER SPOTTED !!!")
+	if len(*userWordInGame) == 0 {
+		return
+	}
+	if !game.sameWord((*userWordInGame)[0], userInputWord) {
+		gs.hotLogFor(client).Debug("word does not match", "expected", (*userWordInGame)[0], "got", userInputWord)
+		if game.suddenDeath {
+			gs.failWord(client, game, (*userWordInGame)[0], userInputWord)
+		}
 		return
 	}
 	totalWords := gs.games[client.room].TotalWords
 	*userWordInGame = (*userWordInGame)[1:]
-	fmt.Println("i DID A SUBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBbb ", userWordInGame)
+	record := gs.games[client.room].PlayerProgress[client.id]
+	record.wordTimes = append(record.wordTimes, time.Now().UTC().UnixMilli()-gs.games[client.room].StartTime)
 	completedWords := totalWords - len(*userWordInGame)
 	var userProgressBar int
 	userProgressBar = int(math.Round((float64(completedWords) / float64(totalWords)) * 100))
-	fmt.Println("this the % of player progress", userProgressBar)
-	fmt.Println(userWordInGame, ")()()()()()()()______________________")
-	gs.userProgress(client , userProgressBar)
-	
-	if len(*userWordInGame) == 0{
-		fmt.Println("user finished the game @@@@@@@@@@@@@@@@@@@@@@@@@@@@")
+	gs.hotLogFor(client).Debug("word completed", "completed", completedWords, "total", totalWords)
+	gs.userProgress(client, userProgressBar)
+
+	if len(*userWordInGame) == 0 {
 		gs.userRanking(client)
-		fmt.Println(gs.games[client.room].leaderBoard)
-		gs.endGame(client , gs.games[client.room].MatchId)
+		gs.logFor(client).Info("player finished", "position", len(gs.games[client.room].leaderBoard))
+		gs.recordRun(client)
+		gs.observeFinish(client)
+		gs.endGame(client, gs.games[client.room].MatchId)
 	}
-		
-	
 
 }
 
+func (gs *GameServer) joinRunningGame(client *Client) {
 
-func (gs *GameServer) joinRunningGame(client *Client){
-
+	if gs.games[client.room].racing(client) {
 		gs.games[client.room].InGameUsers[client.id] = client
+	}
 
-		gs.games[client.room].PlayerProgress[client.id] = &PlayerWordRecord{
-			username: client.username,
-			remainedWords: gs.games[client.room].wordList,
-		}
-		
-		startMessage := struct {
-			Type string    `json:"type"`
-			Text string    `json:"text"`
-			Words []string `json:"words"`
-			Time int64 `json:"startTime"`
-			Language string `json:"language"`
-		}{
-			Type: "startGame",
-			Text: gs.games[client.room].Text,
-			Words: gs.games[client.room].wordList,
-			Time: gs.games[client.room].StartTime,
-			Language: gs.games[client.room].language,
-		}	
-
-		messageBytes, _ := json.Marshal(startMessage)
-
-		select{
-			case client.sendChan <- messageBytes:
-				fmt.Println("message goes to user in the gameee")	
-			default:
-				close(client.sendChan)
-				delete(gs.clients, client.id)
-		}
+	gs.games[client.room].PlayerProgress[client.id] = &PlayerWordRecord{
+		username:      client.username,
+		remainedWords: gs.games[client.room].wordList,
+	}
+
+	messageBytes := gs.startMessage(gs.games[client.room], 0)
+
+	gs.send(client, messageBytes)
+	if game := gs.games[client.room]; game.elimination != nil {
+		gs.send(client, gs.roundMessage(game))
+	}
 }
 
+// startMessage is the startGame message of game. completed is the number of
+// words the receiver already typed, for players resuming the race.
+func (gs *GameServer) startMessage(game *GameState, completed int) []byte {
+	startMessage := struct {
+		Type     string   `json:"type"`
+		Text     string   `json:"text"`
+		Words    []string `json:"words"`
+		Time     int64    `json:"startTime"`
+		Language string   `json:"language"`
+		Mode     string   `json:"mode"`
+		// Direction is ltr or rtl, the way the text is laid out.
+		Direction string `json:"direction"`
+		// SuddenDeath ends the race of a player at the first wrong word.
+		SuddenDeath bool       `json:"suddenDeath"`
+		Difficulty  Difficulty `json:"difficulty"`
+		// Seed recreates the text in a room with the same settings.
+		Seed           uint64 `json:"seed,omitempty"`
+		CompletedWords int    `json:"completedWords"`
+	}{
+		Type:           "startGame",
+		Text:           game.Text,
+		Words:          game.wordList,
+		Time:           game.StartTime,
+		Language:       game.language,
+		Mode:           game.mode,
+		Direction:      gs.direction(game.language),
+		SuddenDeath:    game.suddenDeath,
+		Difficulty:     game.difficulty,
+		Seed:           game.seed,
+		CompletedWords: completed,
+	}
+	messageBytes, _ := json.Marshal(startMessage)
+	return messageBytes
+}
 
-func (gs *GameServer) readyToStart(room string){
+func (gs *GameServer) readyToStart(room string) {
+	if gs.refuseWhileDraining(room) {
+		return
+	}
+	if isHeat, startable := gs.heatStartable(room); isHeat {
+		if startable {
+			gs.startHeatRace(room)
+		}
+		return
+	}
 	if _, exists := gs.games[room]; !exists {
 		gs.startNewGame(room)
-	}else if !gs.games[room].IsActive {
+	} else if !gs.games[room].IsActive {
 		gs.startNewGame(room)
 	}
 
 }
 
-func (gs *GameServer) startNewGame(room string){
-		for _, client := range gs.rooms[room]{
-			client.isReady = false
-		}
-		fmt.Println("game is going to start in this room!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!" , gs.rooms[room])	
-		displayText , wordList := generateCompetitionText(room)
-		inGameUsers := make(map[string]*Client)
-		for id, client := range gs.rooms[room] {
-			inGameUsers[id] = client
-		}
-		language := "en"
-		if room == "room3" {
-			language = "fa"
+func (gs *GameServer) startNewGame(room string) {
+	if custom := gs.takeCustomText(room); custom != nil {
+		gs.startGameWithText(room, custom)
+		return
+	}
+	settings := gs.roomSettings(room)
+	seed := settings.Seed
+	if seed == 0 {
+		seed = newSeed()
+	}
+	if settings.mode() == modeCode {
+		language, snippet, err := gs.randomSnippet(settings.CodeLanguage, newRand(seed))
+		if err != nil {
+			gs.log.Error("loading snippet failed", "room", room, "language", settings.CodeLanguage, "err", err)
+			errorMessage, _ := json.Marshal(map[string]string{"type": "error", "message": "there is no code snippet to race on"})
+			gs.broadcastToRoom(room, errorMessage)
+			return
 		}
-		gameState := &GameState{
-			Text:           displayText,
-			StartTime:      time.Now().UTC().Add(5 * time.Second).UnixMilli(),
-			IsActive:       true,
-			PlayerProgress: make(map[string]*PlayerWordRecord),
-			leaderBoard:    make(map[string]*Client),
-			TotalWords:     len(wordList),
-			wordList:       wordList,
-			language:       language,
-			InGameUsers:    inGameUsers,
-			MatchId:		uuid.New().String(),
+		gs.startGameWithText(room, &raceText{language: language, display: snippet, words: codeLines(snippet), seed: seed})
+		return
+	}
+	lang := gs.roomLanguage(room)
+	if source := settings.source(); source != sourceWords {
+		var displayText string
+		var wordList []string
+		var err error
+		if name, ok := corpusSource(source); ok {
+			displayText, wordList, err = gs.savedText(lang, name, newRand(seed))
+		} else {
+			displayText, wordList, err = gs.corpusText(lang, source, gs.cfg.WordCount, newRand(seed))
 		}
-		fmt.Println("the game should start at ", gameState.StartTime , "DRE")
-		for key , value := range gs.clients {
-			gameState.PlayerProgress[key] = &PlayerWordRecord{
-				username: value.username,
-				remainedWords: wordList,
-			}
+		if err == nil {
+			gs.startGameWithText(room, &raceText{language: lang.Code, display: displayText, words: wordList, seed: seed})
+			return
 		}
-		gs.games[room] = gameState
-
-		
-		
+		gs.log.Warn("corpus text failed, racing on random words", "room", room, "source", source, "err", err)
+	}
+	texts, ok := gs.texts[lang.Code]
+	if !ok {
+		gs.log.Error("no words to race on", "room", room, "language", lang.Code)
+		errorMessage, _ := json.Marshal(map[string]string{"type": "error", "message": "there are no words to race on"})
+		gs.broadcastToRoom(room, errorMessage)
+		return
+	}
+	displayText, wordList := generateCompetitionText(lang, texts.words, gs.cfg.WordCount, settings.Difficulty, newRand(seed))
+	gs.startGameWithText(room, &raceText{language: lang.Code, display: displayText, words: wordList, difficulty: settings.Difficulty, seed: seed})
+}
 
-		startMessage := struct {
-			Type string    `json:"type"`
-			Text string    `json:"text"`
-			Words []string `json:"words"`
-			Time int64 `json:"startTime"`
-			Language string `json:"language"`
-		}{
-			Type: "startGame",
-			Text: gameState.Text,
-			Words: wordList,
-			Time: gameState.StartTime,
-			Language: language,
-		}
-		log.Println("in start New Game Line 129")
-		for key , value := range gameState.PlayerProgress{
-			fmt.Println(key , value)
-		}
-		messageBytes, _ := json.Marshal(startMessage)
-		gs.broadcastToRoom(room , messageBytes)
-		log.Println("after broadcast for ###startNewGame%%%%")
-		fmt.Println(gs.rooms[room], "^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<")
-		for key , value := range gs.rooms[room]{
-			fmt.Println(key , "MTF key")
-			fmt.Println(value , "MTF value")
+func (gs *GameServer) startGameWithText(room string, text *raceText) {
+	displayText, wordList, language := text.display, text.words, text.language
+	for _, client := range gs.rooms[room] {
+		client.isReady = client.isBot
+	}
+	inGameUsers := make(map[string]*Client)
+	for id, client := range gs.rooms[room] {
+		inGameUsers[id] = client
+	}
+	settings := gs.roomSettings(room)
+	normalization := gs.normalization(language)
+	if settings.mode() != modeCode {
+		wordList = normalization.normalizeWords(wordList)
+		displayText = normalization.text(displayText)
+	}
+	gameState := &GameState{
+		Text:           displayText,
+		StartTime:      time.Now().UTC().Add(gs.cfg.StartDelay).UnixMilli(),
+		IsActive:       true,
+		PlayerProgress: make(map[string]*PlayerWordRecord),
+		leaderBoard:    make(map[string]*Client),
+		TotalWords:     len(wordList),
+		wordList:       wordList,
+		language:       language,
+		InGameUsers:    inGameUsers,
+		MatchId:        uuid.New().String(),
+		progress:       make(map[string]*racerProgress),
+		mode:           settings.mode(),
+		suddenDeath:    settings.SuddenDeath,
+		failed:         make(map[string]int),
+		difficulty:     text.difficulty,
+		seed:           text.seed,
+		normalization:  normalization,
+	}
+	for key, value := range gs.clients {
+		gameState.PlayerProgress[key] = &PlayerWordRecord{
+			username:      value.username,
+			remainedWords: wordList,
 		}
-		fmt.Println(gs.games[room].InGameUsers, "{}{}{}{}{}{}")
+	}
+	switch gameState.mode {
+	case modeRelay:
+		setupRelay(gameState, settings.teams())
+	case modeElimination:
+		gs.eliminationRound(room, gameState, gs.games[room])
+	}
+	gs.games[room] = gameState
+	racesStarted.Inc()
+	if gs.cfg.ProgressTick > 0 {
+		go gs.runProgressTicker(room, gameState)
+	}
+
+	messageBytes := gs.startMessage(gameState, 0)
+	gs.log.Info("race started", "room", room, "match", gameState.MatchId, "players", len(inGameUsers), "words", len(wordList), "language", language, "mode", gameState.mode, "seed", gameState.seed)
+	gs.broadcastToRoom(room, messageBytes)
+	switch gameState.mode {
+	case modeRelay:
+		gs.startRelay(room, gameState)
+	case modeElimination:
+		gs.announceRound(room, gameState)
+	}
 
 }
 
+func (gs *GameServer) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if gs.draining.Load() {
+		w.Header().Set("Retry-After", strconv.Itoa(int(gs.cfg.ReconnectDelay.Seconds())))
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if !gs.checkOrigin(r) {
+		gs.rejectConnection(w, r, http.StatusForbidden, "origin")
+		return
+	}
+	if len(strings.Join(r.Header.Values("Sec-WebSocket-Protocol"), ", ")) > gs.cfg.MaxProtocolHeaderBytes {
+		gs.rejectConnection(w, r, http.StatusRequestHeaderFieldsTooLarge, "protocol_header")
+		return
+	}
+	ip := gs.clientIP(r)
+	if !gs.acquireIP(ip) {
+		gs.rejectConnection(w, r, http.StatusTooManyRequests, "ip_limit")
+		return
+	}
+	username, authenticated := gs.identify(r)
+	room := r.URL.Query().Get("room")
+	if room != "" && gs.requiresAuth(room) && !authenticated {
+		gs.releaseIP(ip)
+		gs.rejectConnection(w, r, http.StatusUnauthorized, "auth_required")
+		return
+	}
 
-func (gs *GameServer) HandleWebSocket(w http.ResponseWriter, r *http.Request){
 	conn, err := gs.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println("Upgrade error:", err)
+		gs.releaseIP(ip)
+		gs.log.Warn("websocket upgrade failed", "remote", r.RemoteAddr, "err", err)
 		return
 	}
-	var username string
-	protocols := websocket.Subprotocols(r)
-	for _, protocol := range protocols {
-		if strings.HasPrefix(protocol, "auth_token:"){
-			token := strings.TrimPrefix(protocol , "auth_token:")
-			auth, err := verifyTokenWithFastAPI(token)
-			fmt.Println("GONNA SEE SOME ERRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRR??")
-			if err != nil{
-				fmt.Println("here is the VALLLLLLLLLLLLLLLLLLLLLLLLLLLLLLLLLLLLLLLLLLLLIDDDDDDDDDD")
-				fmt.Println(auth.Valid)
-				username = auth.UserName
-			}
-		}else if strings.HasPrefix(protocol, "nickname:"){
-			if username == ""{
-				username = strings.TrimPrefix(protocol, "nickname:")
-			}
-		}
-	}
-	username = fmt.Sprintf("Guest_%d", time.Now().UnixNano()%10000)
-	log.Printf("User comes as %s ", username)
+	connectedClients.Inc()
 	client := &Client{
-		conn: conn,
-		id: fmt.Sprintf("%s_%d", username, time.Now().UnixNano()),
-		username: username,
-		room: r.URL.Query().Get("room"),
-		sendChan: make(chan []byte, 256),
+		conn:          conn,
+		id:            fmt.Sprintf("%s_%d", username, time.Now().UnixNano()),
+		session:       uuid.New().String(),
+		username:      username,
+		room:          room,
+		outbox:        newOutbox(gs.cfg.SendBufferSize, gs.cfg.SlowClientMaxDropped),
+		authenticated: authenticated,
+		ip:            ip,
+		limiter:       newRateLimiter(gs.cfg.RateLimits),
 	}
 
 	joinMessage := struct {
-		Type string `json:"type"`
+		Type     string `json:"type"`
 		Username string `json:"username"`
-		Content string `json:"content"`
+		Content  string `json:"content"`
 	}{
-		Type: "join",
+		Type:     "join",
 		Username: username,
-		Content: fmt.Sprintf("%s joined the game", client.username), 
-
+		Content:  fmt.Sprintf("%s joined the game", client.username),
 	}
-	
+
+	gs.mutex.Lock()
+	gs.connections[client] = struct{}{}
+	gs.mutex.Unlock()
+	gs.logFor(client).Info("client connected", "remote", r.RemoteAddr)
+	gs.sendSession(client)
 	joinMessageBytes, _ := json.Marshal(joinMessage)
 	gs.broadcastToRoom(client.room, joinMessageBytes)
 
-
-
 	go gs.readPump(client)
 	go gs.writePump(client)
 
 }
 
-func (gs *GameServer) readPump(client *Client){
-	defer func(){
+func (gs *GameServer) readPump(client *Client) {
+	defer func() {
+		gs.detachRemote(client)
+		gs.unwatchTournaments(client)
 		gs.unregister <- client
 		client.conn.Close()
+		gs.mutex.Lock()
+		delete(gs.connections, client)
+		gs.mutex.Unlock()
+		gs.releaseIP(client.ip)
+		connectedClients.Dec()
 	}()
 
 	for {
-		messageType, message , err := client.conn.ReadMessage()	
+		messageType, message, err := client.conn.ReadMessage()
 		if err != nil {
-			log.Printf("Error reading message: %v", err)
+			gs.logFor(client).Debug("connection closed", "err", err)
 			break
 		}
 		if messageType == websocket.TextMessage {
-			gs.handleGameMessage(client , message)
+			gs.handleGameMessage(client, message)
 		}
 
 	}
 }
 
-
-func (gs *GameServer) writePump(client *Client){
+func (gs *GameServer) writePump(client *Client) {
 	defer client.conn.Close()
 
 	for {
-		select {
-		case message, ok := <- client.sendChan:
-			if !ok {
-				client.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
-			err := client.conn.WriteMessage(websocket.TextMessage, message)
-			if err != nil {
-				log.Println("error writing message:", err)
-				return
+		message, ok := client.outbox.next()
+		if !ok {
+			closeMessage := []byte{}
+			if reason := client.outbox.closeReason(); reason != "" {
+				closeMessage = websocket.FormatCloseMessage(websocket.CloseTryAgainLater, reason)
 			}
-			log.Println(string(message), "print in writePum")
+			client.conn.WriteMessage(websocket.CloseMessage, closeMessage)
+			return
+		}
+		err := client.conn.WriteMessage(websocket.TextMessage, message)
+		if err != nil {
+			gs.logFor(client).Warn("writing message failed", "err", err)
+			return
 		}
+		messageType := outgoingType(message)
+		messagesOut.WithLabelValues(messageType).Inc()
+		gs.hotLogFor(client).Debug("message sent", "type", messageType)
 	}
 }
 
-
-func (gs *GameServer) userCred(client *Client, messageContent json.RawMessage){
+func (gs *GameServer) userCred(client *Client, messageContent json.RawMessage) {
 	var result map[string]string
 	err := json.Unmarshal(messageContent, &result)
-	if err != nil{
-		fmt.Println("error while parsing message in wordComplete method")
+	if err != nil {
+		gs.logFor(client).Warn("invalid usercred message", "err", err)
+	}
+	if client.authenticated {
+		return
+	}
+	if gs.isHeatRoom(client.room) {
+		gs.sendError(client, "names cannot change during a tournament heat")
+		return
 	}
 	client.username = result["username"]
 }
 
-func (gs *GameServer) handleGameMessage(client *Client, message []byte){
+func (gs *GameServer) sendError(client *Client, message string) {
+	errorMessage := struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	}{
+		Type:    "error",
+		Message: message,
+	}
+	messageBytes, _ := json.Marshal(errorMessage)
+	gs.send(client, messageBytes)
+}
+
+// dropSlowClient disconnects a client that keeps falling behind. Its write
+// pump sends the close frame, and unregistering removes it from its room.
+func (gs *GameServer) dropSlowClient(client *Client) {
+	sendOverflows.Inc()
+	gs.logFor(client).Warn("client too slow, disconnecting", "queued", client.outbox.len())
+	client.outbox.close("client too slow")
+}
+
+func (gs *GameServer) handleGameMessage(client *Client, message []byte) {
 	var gameMessage struct {
-		Type string `json:"type"`
+		Type    string          `json:"type"`
 		Content json.RawMessage `json:"content"`
 	}
 
-	if err := json.Unmarshal(message, &gameMessage) ; err != nil {
-		log.Printf("Error parsing message")
-		return 
+	if err := json.Unmarshal(message, &gameMessage); err != nil {
+		gs.logFor(client).Warn("invalid message", "err", err)
+		return
 	}
-	switch gameMessage.Type{
-	case "roomsStatus" :
+	gs.hotLogFor(client).Debug("message received", "type", gameMessage.Type)
+	if !gs.allowMessage(client, gameMessage.Type) {
+		return
+	}
+	if client.homeNode == "" && gs.routeMessage(client, gameMessage.Type, message) {
+		return
+	}
+	switch gameMessage.Type {
+	case "roomsStatus":
 		gs.roomsStatus(client)
 	case "join":
-		log.Println("a joining is happening !!!")
-		gs.joinPlayer(client , gameMessage.Content)
+		gs.joinPlayer(client, gameMessage.Content)
 	case "ready":
-		log.Println("player clicked the ready button!!!!")
 		gs.readyPlayer(client)
 	case "startGame":
-		log.Println("New Game!!!!")
-		gs.readyToStart(client.room)
+		if wantsGhost(gameMessage.Content) {
+			gs.startGhostRace(client)
+		} else {
+			gs.readyToStart(client.room)
+		}
 	case "wordComplete":
-		log.Println("new words come in ! ( print in handleGameMessage)")
-		gs.wordComplete(client , gameMessage.Content)
+		gs.wordComplete(client, gameMessage.Content)
+	case "lineComplete":
+		gs.lineComplete(client, gameMessage.Content)
 	case "endGame":
-		log.Println("+++++++++++++ENG GAME SOLDIER+++++++++++++")
 	case "roomStatus":
-		log.Println("going into roomStatus Method ( in handleGameMessage)")
 		gs.roomStatus(client)
 	case "usercred":
-		gs.userCred(client , gameMessage.Content)
-		
-	//case "endGame":
-	//	log.Println("End Game!!!!")
-	//	gs.endGameMessageHandler()	
-
-	}	
+		gs.userCred(client, gameMessage.Content)
+	case "resume":
+		gs.resumeSession(client, gameMessage.Content)
+	case "tournament":
+		gs.watchTournament(client, gameMessage.Content)
+	case "roomSettings":
+		gs.updateRoomSettings(client, gameMessage.Content)
+	case "customText":
+		gs.submitCustomText(client, gameMessage.Content)
+	case "addBot":
+		gs.addBot(client, gameMessage.Content)
+	case "removeBot":
+		gs.removeBot(client)
+	default:
+		gameMessage.Type = "unknown"
+
+		//case "endGame":
+		//	log.Println("End Game!!!!")
+		//	gs.endGameMessageHandler()
 
+	}
+	messagesIn.WithLabelValues(gameMessage.Type).Inc()
 
 	//enrichedMessage := struct {
 	//	Type     string          `json:"type"`
@@ -717,63 +908,30 @@ func (gs *GameServer) handleGameMessage(client *Client, message []byte){
 	//enrichedMessageBytes, _ := json.Marshal(enrichedMessage)
 	//gs.broadcastToRoom(client.room , enrichedMessageBytes)
 
-	
 }
 
-
-func (gs *GameServer) broadcastToRoom(room string, message []byte){
-	log.Println("start of broadcast")
+func (gs *GameServer) broadcastToRoom(room string, message []byte) {
 	gs.mutex.Lock()
-	log.Println("after Lock")
 	defer gs.mutex.Unlock()
-	if clients, ok := gs.rooms[room]; ok {
-		fmt.Println("found a client!!!!!!!!!!!!!!", clients)
-		for _, client := range clients {
-			select{
-			case client.sendChan <- message:
-				var enrichedMessage struct {
-						Type     string          `json:"type"`
-						Progress     map[string]*GameState           `json:"progress"`
-						Username string          `json:"username"`
-						Content  json.RawMessage `json:"content"`
-					}
-				fmt.Println(client.sendChan, "How")
-				if err := json.Unmarshal(message , &enrichedMessage) ; err != nil {
-					log.Printf("Error parsing message")
-					return 
-				}
-				fmt.Println(enrichedMessage)
-
-			default:
-				close(client.sendChan)
-				delete(gs.rooms[room], client.id)
-				delete(gs.clients, client.id)
-			}
-		}
+	queued := newOutgoing(message)
+	for _, client := range gs.rooms[room] {
+		gs.sendOutgoing(client, queued)
 	}
-	log.Println("no clients in the room")
-	fmt.Println(gs.rooms[room] , " the clients are")
-	fmt.Println(room, " room is")
 }
 
-
-
-
-
-
 type AuthRequest struct {
-	Scheme string `json:"scheme"`
+	Scheme      string `json:"scheme"`
 	Credentials string `json:"credentials"`
 }
 
 type AuthResponse struct {
-	Valid bool
+	Valid    bool
 	UserName string
 }
 
-func verifyTokenWithFastAPI(token string) (*AuthResponse, error) {
+func verifyTokenWithFastAPI(apiURL string, token string) (*AuthResponse, error) {
 	authReq := AuthRequest{
-		Scheme: "bearer",
+		Scheme:      "bearer",
 		Credentials: token,
 	}
 	jsonData, err := json.Marshal(authReq)
@@ -781,31 +939,117 @@ func verifyTokenWithFastAPI(token string) (*AuthResponse, error) {
 		return nil, err
 	}
 	resp, err := http.Post(
-		"http://127.0.0.1:8000/verify",
+		strings.TrimSuffix(apiURL, "/")+"/verify",
 		"application/json",
 		bytes.NewBuffer(jsonData),
 	)
 	if err != nil {
-		return nil , err
+		return nil, err
 	}
+	defer resp.Body.Close()
 	var result AuthResponse
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil,err
+		return nil, err
 	}
 	return &result, nil
 }
 
-
-
 func main() {
 	godotenv.Load(".env")
-	portString := os.Getenv("PORT")
-    gameServer := NewGameServer("ws://127.0.0.1:8000/ws")
-    go gameServer.Run()
-
-    http.HandleFunc("/ws", gameServer.HandleWebSocket) // passing HandleWebSocket method for HandleFunc method ass a value ( that first citizen function kind of things )
-	log.Printf("Server starting on port %v", portString)
-    log.Fatal(http.ListenAndServe("0.0.0.0:9000", nil))
-}
+	cfg, err := LoadConfig(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid configuration:", err)
+		os.Exit(2)
+	}
+	logger := newLogger(os.Stdout, cfg.LogLevel, cfg.LogFormat)
+	slog.SetDefault(logger)
+	runs, err := NewRunStore(cfg.DataDir)
+	if err != nil {
+		logger.Error("opening data directory failed", "dir", cfg.DataDir, "err", err)
+		os.Exit(1)
+	}
+	broker, err := newBroker(cfg)
+	if err != nil {
+		logger.Error("connecting to broker failed", "broker", cfg.Broker, "err", err)
+		os.Exit(1)
+	}
+	defer broker.Close()
+	gameServer := NewGameServer(cfg, runs, broker, logger)
+	if err := gameServer.LoadTexts(); err != nil {
+		logger.Error("loading language corpora failed", "dir", cfg.TextsDir, "err", err)
+	}
+	go gameServer.Run()
+	stopSnapshots := make(chan struct{})
+	if gameServer.snapshotsEnabled() {
+		if err := gameServer.RestoreSnapshot(); err != nil {
+			logger.Error("restoring snapshot failed", "err", err)
+		}
+		go gameServer.RunSnapshots(stopSnapshots)
+	}
+	if err := gameServer.LoadTournaments(); err != nil {
+		logger.Error("loading tournaments failed", "err", err)
+	}
+	clusterCtx, stopCluster := context.WithCancel(context.Background())
+	defer stopCluster()
+	if err := gameServer.StartCluster(clusterCtx); err != nil {
+		logger.Error("joining cluster failed", "node", cfg.NodeID, "err", err)
+		os.Exit(1)
+	}
 
+	prometheus.MustRegister(gameServer)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", gameServer.HandleHealth)
+	mux.HandleFunc("/readyz", gameServer.HandleReady)
+	mux.HandleFunc("/version", HandleVersion)
+	mux.HandleFunc("GET /tournaments", gameServer.HandleListTournaments)
+	mux.HandleFunc("POST /tournaments", gameServer.HandleCreateTournament)
+	mux.HandleFunc("GET /tournaments/{id}", gameServer.HandleGetTournament)
+	mux.HandleFunc("POST /tournaments/{id}/start", gameServer.HandleStartTournament)
+	mux.HandleFunc("/ws", gameServer.HandleWebSocket) // passing HandleWebSocket method for HandleFunc method ass a value ( that first citizen function kind of things )
+	server := &http.Server{Addr: cfg.Addr(), Handler: mux}
+	connectionsClosed := make(chan struct{})
+	server.RegisterOnShutdown(func() {
+		gameServer.CloseConnections()
+		close(connectionsClosed)
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Info("server starting", "addr", cfg.Addr())
+		serveErr <- server.ListenAndServe()
+	}()
 
+	select {
+	case err := <-serveErr:
+		logger.Error("server stopped", "err", err)
+		os.Exit(1)
+	case <-ctx.Done():
+	}
+	stop()
+
+	logger.Info("shutting down", "timeout", cfg.ShutdownTimeout)
+	drainCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	gameServer.Drain(drainCtx)
+	close(stopSnapshots)
+	if gameServer.snapshotsEnabled() {
+		if err := gameServer.SaveSnapshot(); err != nil {
+			logger.Error("saving snapshot failed", "err", err)
+		}
+	}
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelShutdown()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error("http shutdown failed", "err", err)
+	}
+	// Shutdown runs the hooks in their own goroutines without waiting for them.
+	select {
+	case <-connectionsClosed:
+	case <-shutdownCtx.Done():
+	}
+	gameServer.ReleaseRooms(shutdownCtx)
+	logger.Info("server stopped")
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	connectedClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "elevenfingers_connected_clients",
+		Help: "Number of open WebSocket connections.",
+	})
+	racesStarted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "elevenfingers_races_started_total",
+		Help: "Number of races started.",
+	})
+	racesFinished = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "elevenfingers_races_finished_total",
+		Help: "Number of races that ended.",
+	})
+	messagesIn = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "elevenfingers_messages_received_total",
+		Help: "Messages received from clients by type.",
+	}, []string{"type"})
+	messagesOut = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "elevenfingers_messages_sent_total",
+		Help: "Messages written to clients by type.",
+	}, []string{"type"})
+	sendOverflows = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "elevenfingers_send_buffer_overflows_total",
+		Help: "Clients disconnected because they fell too far behind.",
+	})
+	messagesDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "elevenfingers_messages_dropped_total",
+		Help: "Queued messages dropped for clients that fell behind, by type.",
+	}, []string{"type"})
+	messagesCoalesced = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "elevenfingers_messages_coalesced_total",
+		Help: "Queued messages replaced by a newer one of the same kind, by type.",
+	}, []string{"type"})
+	authFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "elevenfingers_auth_failures_total",
+		Help: "Connections whose auth token could not be verified.",
+	})
+	messagesThrottled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "elevenfingers_messages_throttled_total",
+		Help: "Client messages dropped by the rate limiter by limit.",
+	}, []string{"limit"})
+	clientsThrottled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "elevenfingers_clients_throttled_total",
+		Help: "Flooding clients that were warned or disconnected.",
+	}, []string{"action"})
+	connectionsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "elevenfingers_connections_rejected_total",
+		Help: "Handshakes refused by the connection policy by reason.",
+	}, []string{"reason"})
+	clusterMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "elevenfingers_cluster_messages_total",
+		Help: "Messages received from other nodes by kind.",
+	}, []string{"kind"})
+	raceDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "elevenfingers_race_duration_seconds",
+		Help:    "Time players needed to finish a race.",
+		Buckets: prometheus.ExponentialBuckets(2, 1.5, 12),
+	})
+	raceWPM = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "elevenfingers_race_wpm",
+		Help:    "Typing speed of players that finished a race.",
+		Buckets: prometheus.LinearBuckets(10, 10, 18),
+	})
+
+	roomClientsDesc = prometheus.NewDesc(
+		"elevenfingers_room_clients",
+		"Number of clients in each room.",
+		[]string{"room"}, nil,
+	)
+	activeGamesDesc = prometheus.NewDesc(
+		"elevenfingers_active_games",
+		"Number of races currently running.",
+		nil, nil,
+	)
+)
+
+// Describe and Collect make the GameServer a prometheus.Collector for the
+// values that are read from the room state at scrape time.
+func (gs *GameServer) Describe(ch chan<- *prometheus.Desc) {
+	ch <- roomClientsDesc
+	ch <- activeGamesDesc
+}
+
+func (gs *GameServer) Collect(ch chan<- prometheus.Metric) {
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+	for room, clients := range gs.rooms {
+		ch <- prometheus.MustNewConstMetric(roomClientsDesc, prometheus.GaugeValue, float64(len(clients)), room)
+	}
+	active := 0
+	for _, game := range gs.games {
+		if game.IsActive {
+			active++
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(activeGamesDesc, prometheus.GaugeValue, float64(active))
+}
+
+// observeFinish records duration and speed of a human that finished the race.
+func (gs *GameServer) observeFinish(client *Client) {
+	if client.isBot {
+		return
+	}
+	game := gs.games[client.room]
+	durationMs := time.Now().UTC().UnixMilli() - game.StartTime
+	raceDuration.Observe(float64(durationMs) / 1000)
+	raceWPM.Observe(wordsPerMinute(len([]rune(game.Text)), durationMs))
+}
+
+// outgoingType extracts the type of a message the server is about to write.
+func outgoingType(message []byte) string {
+	var typed struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(message, &typed); err != nil || typed.Type == "" {
+		return "unknown"
+	}
+	return typed.Type
+}
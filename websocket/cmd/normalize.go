@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// ZWNJ policies.
+const (
+	zwnjStrict   = "strict"   // a word only matches with the same zero width non-joiners
+	zwnjOptional = "optional" // zero width non-joiners are ignored when matching words
+)
+
+const zwnj = '\u200c'
+
+// digitZeros are the zeros of the digit variants a language can fold to.
+var digitZeros = map[string]rune{
+	"ascii":   '0',
+	"arabic":  '٠',
+	"persian": '۰',
+}
+
+// TextNormalization is how the texts of a language are brought into one form
+// and how typed words are matched against them. Every language gets NFC.
+type TextNormalization struct {
+	// FoldArabic replaces Arabic yeh, alef maksura and kaf with Persian yeh
+	// and keheh.
+	FoldArabic bool `yaml:"foldArabic"`
+	// ZWNJ is strict (the default) or optional.
+	ZWNJ string `yaml:"zwnj"`
+	// Digits folds ASCII, Arabic-Indic and Persian digits into ascii,
+	// arabic or persian ones. Empty keeps them as they are.
+	Digits string `yaml:"digits"`
+}
+
+func (n *TextNormalization) validate() error {
+	if n.ZWNJ != "" && n.ZWNJ != zwnjStrict && n.ZWNJ != zwnjOptional {
+		return fmt.Errorf("zwnj must be %s or %s", zwnjStrict, zwnjOptional)
+	}
+	if _, ok := digitZeros[n.Digits]; n.Digits != "" && !ok {
+		return fmt.Errorf("unknown digits %q", n.Digits)
+	}
+	return nil
+}
+
+// text brings corpus text and the words of a race into the normal form of
+// the language. A nil normalization only applies NFC.
+func (n *TextNormalization) text(s string) string {
+	s = norm.NFC.String(s)
+	if n == nil || (!n.FoldArabic && n.Digits == "") {
+		return s
+	}
+	zero, foldDigits := digitZeros[n.Digits]
+	return strings.Map(func(r rune) rune {
+		if n.FoldArabic {
+			switch r {
+			case '\u064a', '\u0649': // Arabic yeh, alef maksura
+				return '\u06cc' // Persian yeh
+			case '\u0643': // Arabic kaf
+				return '\u06a9' // keheh
+			}
+		}
+		if foldDigits {
+			for _, variant := range digitZeros {
+				if r >= variant && r <= variant+9 {
+					return zero + (r - variant)
+				}
+			}
+		}
+		return r
+	}, s)
+}
+
+// matchKey is what typed words are compared by: the normal form, without
+// zero width non-joiners when they are optional.
+func (n *TextNormalization) matchKey(word string) string {
+	word = n.text(word)
+	if n != nil && n.ZWNJ == zwnjOptional {
+		word = strings.ReplaceAll(word, string(zwnj), "")
+	}
+	return word
+}
+
+// normalizeWords returns the words of a race in the normal form of the
+// language, leaving words untouched.
+func (n *TextNormalization) normalizeWords(words []string) []string {
+	normalized := make([]string, len(words))
+	for i, word := range words {
+		normalized[i] = n.text(word)
+	}
+	return normalized
+}
+
+// sameWord tells whether a typed word matches the expected one of game.
+func (game *GameState) sameWord(expected string, typed string) bool {
+	return expected == typed || game.normalization.matchKey(expected) == game.normalization.matchKey(typed)
+}
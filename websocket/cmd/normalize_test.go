@@ -0,0 +1,86 @@
+package main
+
+import "testing"
+
+func TestMatchKey(t *testing.T) {
+	persian := &TextNormalization{FoldArabic: true, ZWNJ: zwnjOptional, Digits: "persian"}
+	tests := []struct {
+		name          string
+		normalization *TextNormalization
+		word          string
+		want          string
+	}{
+		{"nil applies NFC", nil, "cafe\u0301", "caf\u00e9"},
+		{"nil keeps Arabic letters", nil, "علي", "علي"},
+		{"nil keeps zwnj", nil, "می\u200cروم", "می\u200cروم"},
+		{"nil keeps digits", nil, "۱٢3", "۱٢3"},
+		{"empty keeps Arabic letters", &TextNormalization{}, "كتاب", "كتاب"},
+		{"yeh", &TextNormalization{FoldArabic: true}, "علي", "علی"},
+		{"alef maksura", &TextNormalization{FoldArabic: true}, "موسى", "موسی"},
+		{"kaf", &TextNormalization{FoldArabic: true}, "كتاب", "کتاب"},
+		{"strict zwnj", &TextNormalization{ZWNJ: zwnjStrict}, "می\u200cروم", "می\u200cروم"},
+		{"optional zwnj", &TextNormalization{ZWNJ: zwnjOptional}, "می\u200cروم", "میروم"},
+		{"ascii digits", &TextNormalization{Digits: "ascii"}, "۱٢3", "123"},
+		{"arabic digits", &TextNormalization{Digits: "arabic"}, "۱٢3", "١٢٣"},
+		{"persian digits", &TextNormalization{Digits: "persian"}, "۱٢3", "۱۲۳"},
+		{"digits keep letters", &TextNormalization{Digits: "ascii"}, "a۹z", "a9z"},
+		{"persian", persian, "كي\u200cها ١٤٠٢", "کیها ۱۴۰۲"},
+	}
+	for _, test := range tests {
+		if got := test.normalization.matchKey(test.word); got != test.want {
+			t.Errorf("%s: matchKey(%q) = %q, want %q", test.name, test.word, got, test.want)
+		}
+	}
+}
+
+func TestSameWord(t *testing.T) {
+	tests := []struct {
+		name          string
+		normalization *TextNormalization
+		expected      string
+		typed         string
+		want          bool
+	}{
+		{"nil same", nil, "word", "word", true},
+		{"nil NFC", nil, "caf\u00e9", "cafe\u0301", true},
+		{"nil different", nil, "word", "ward", false},
+		{"nil Arabic yeh", nil, "علی", "علي", false},
+		{"yeh folded", &TextNormalization{FoldArabic: true}, "علی", "علي", true},
+		{"kaf folded", &TextNormalization{FoldArabic: true}, "کتاب", "كتاب", true},
+		{"yeh not folded", &TextNormalization{}, "علی", "علي", false},
+		{"strict zwnj missing", &TextNormalization{ZWNJ: zwnjStrict}, "می\u200cروم", "میروم", false},
+		{"strict zwnj same", &TextNormalization{ZWNJ: zwnjStrict}, "می\u200cروم", "می\u200cروم", true},
+		{"optional zwnj missing", &TextNormalization{ZWNJ: zwnjOptional}, "می\u200cروم", "میروم", true},
+		{"optional zwnj extra", &TextNormalization{ZWNJ: zwnjOptional}, "میروم", "می\u200cروم", true},
+		{"optional zwnj other word", &TextNormalization{ZWNJ: zwnjOptional}, "می\u200cروم", "میرود", false},
+		{"digits not folded", &TextNormalization{}, "۱۴۰۲", "1402", false},
+		{"persian digits typed as ascii", &TextNormalization{Digits: "persian"}, "۱۴۰۲", "1402", true},
+		{"persian digits typed as arabic", &TextNormalization{Digits: "persian"}, "۱۴۰۲", "١٤٠٢", true},
+		{"ascii digits typed as persian", &TextNormalization{Digits: "ascii"}, "42", "۴۲", true},
+		{"other digits", &TextNormalization{Digits: "ascii"}, "42", "۴۳", false},
+	}
+	for _, test := range tests {
+		game := &GameState{normalization: test.normalization}
+		if got := game.sameWord(test.expected, test.typed); got != test.want {
+			t.Errorf("%s: sameWord(%q, %q) = %v, want %v", test.name, test.expected, test.typed, got, test.want)
+		}
+	}
+}
+
+func TestTextNormalizationValidate(t *testing.T) {
+	tests := []struct {
+		normalization TextNormalization
+		valid         bool
+	}{
+		{TextNormalization{}, true},
+		{TextNormalization{FoldArabic: true, ZWNJ: zwnjOptional, Digits: "persian"}, true},
+		{TextNormalization{ZWNJ: zwnjStrict, Digits: "ascii"}, true},
+		{TextNormalization{ZWNJ: "ignore"}, false},
+		{TextNormalization{Digits: "roman"}, false},
+	}
+	for _, test := range tests {
+		if err := test.normalization.validate(); (err == nil) != test.valid {
+			t.Errorf("%+v: got %v, want valid %v", test.normalization, err, test.valid)
+		}
+	}
+}
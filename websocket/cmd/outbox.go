@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// outgoing is a message queued for a client together with what the outbox
+// needs to know about it.
+type outgoing struct {
+	data        []byte
+	kind        string // message type, for metrics
+	coalesceKey string // a newer message with the same key replaces this one
+	critical    bool   // never dropped to make room
+}
+
+// criticalMessages must reach the client; without them it can't tell when a
+// race starts or ends.
+var criticalMessages = map[string]bool{
+	"startGame":        true,
+	"endGame":          true,
+	"playerRank":       true,
+	"serverShutdown":   true,
+	"relayStart":       true,
+	"relayLeg":         true,
+	"teamFinished":     true,
+	"elimination":      true,
+	"eliminationRound": true,
+	"playerEliminated": true,
+}
+
+// newOutgoing classifies message. Broadcasts do this once for all receivers.
+func newOutgoing(message []byte) outgoing {
+	var header struct {
+		Type   string `json:"type"`
+		Userid string `json:"userid"`
+	}
+	json.Unmarshal(message, &header)
+	out := outgoing{data: message, kind: header.Type, critical: criticalMessages[header.Type]}
+	if out.kind == "" {
+		out.kind = "unknown"
+	}
+	switch header.Type {
+	case "userProgress":
+		out.coalesceKey = "userProgress:" + header.Userid
+	case "raceProgress", "teamProgress", "roomStatus", "roomsStatus":
+		out.coalesceKey = header.Type
+	}
+	return out
+}
+
+// outbox is the queue between the game logic and a client's write pump. It
+// never blocks the sender: superseded messages are coalesced, and when the
+// queue is full the oldest non-critical message is dropped. A client that
+// keeps falling behind is closed with a reason.
+type outbox struct {
+	mutex      sync.Mutex
+	queue      []outgoing
+	limit      int
+	maxDropped int
+	dropped    int // messages lost since the queue was last empty
+	closed     bool
+	reason     string
+	wake       chan struct{}
+}
+
+func newOutbox(limit int, maxDropped int) *outbox {
+	return &outbox{limit: limit, maxDropped: maxDropped, wake: make(chan struct{}, 1)}
+}
+
+// push queues message. It returns false when the client is too far behind;
+// the caller is expected to disconnect it.
+func (o *outbox) push(message outgoing) bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.closed {
+		return true
+	}
+	defer o.signal()
+	if message.coalesceKey != "" {
+		for i := range o.queue {
+			if o.queue[i].coalesceKey == message.coalesceKey {
+				o.queue[i] = message
+				messagesCoalesced.WithLabelValues(message.kind).Inc()
+				return true
+			}
+		}
+	}
+	if len(o.queue) >= o.limit {
+		victim := -1
+		for i := range o.queue {
+			if !o.queue[i].critical {
+				victim = i
+				break
+			}
+		}
+		if victim < 0 && !message.critical {
+			victim = len(o.queue) // nothing left to drop but the new message
+		}
+		if victim < 0 {
+			return false
+		}
+		dropped := message
+		if victim < len(o.queue) {
+			dropped = o.queue[victim]
+			o.queue = append(o.queue[:victim], o.queue[victim+1:]...)
+			o.queue = append(o.queue, message)
+		}
+		messagesDropped.WithLabelValues(dropped.kind).Inc()
+		o.dropped++
+		return o.maxDropped == 0 || o.dropped < o.maxDropped
+	}
+	o.queue = append(o.queue, message)
+	return true
+}
+
+// next blocks until a message is queued and returns it, or returns false
+// once the outbox is closed. Messages still queued on close are discarded.
+func (o *outbox) next() ([]byte, bool) {
+	for {
+		o.mutex.Lock()
+		if o.closed {
+			o.mutex.Unlock()
+			return nil, false
+		}
+		if len(o.queue) > 0 {
+			message := o.queue[0]
+			o.queue[0] = outgoing{}
+			o.queue = o.queue[1:]
+			if len(o.queue) == 0 {
+				o.dropped = 0
+			}
+			o.mutex.Unlock()
+			return message.data, true
+		}
+		o.mutex.Unlock()
+		<-o.wake
+	}
+}
+
+// close stops the outbox. reason is sent to the client in the close frame;
+// only the first call has an effect.
+func (o *outbox) close(reason string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.closed {
+		return
+	}
+	o.closed = true
+	o.reason = reason
+	o.queue = nil
+	o.signal()
+}
+
+// closeReason is the reason given to close, empty for a normal close.
+func (o *outbox) closeReason() string {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.reason
+}
+
+func (o *outbox) len() int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return len(o.queue)
+}
+
+func (o *outbox) signal() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// send queues message for client and disconnects the client when it has
+// fallen too far behind.
+func (gs *GameServer) send(client *Client, message []byte) {
+	gs.sendOutgoing(client, newOutgoing(message))
+}
+
+func (gs *GameServer) sendOutgoing(client *Client, message outgoing) {
+	if !client.outbox.push(message) {
+		gs.dropSlowClient(client)
+	}
+}
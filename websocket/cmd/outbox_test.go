@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOutboxPush(t *testing.T) {
+	const (
+		start     = `{"type":"startGame"}`
+		end       = `{"type":"endGame"}`
+		joinA     = `{"type":"join","username":"a"}`
+		joinB     = `{"type":"join","username":"b"}`
+		joinC     = `{"type":"join","username":"c"}`
+		race1     = `{"type":"raceProgress","tick":1}`
+		race2     = `{"type":"raceProgress","tick":2}`
+		progressA = `{"type":"userProgress","userid":"a","percentage":10}`
+		progressB = `{"type":"userProgress","userid":"b","percentage":10}`
+		laterA    = `{"type":"userProgress","userid":"a","percentage":20}`
+	)
+	tests := []struct {
+		name       string
+		limit      int
+		maxDropped int
+		messages   []string
+		wantQueue  []string
+		wantPushed []bool
+	}{
+		{"queued in order", 4, 0, []string{start, joinA, end}, []string{start, joinA, end}, []bool{true, true, true}},
+		{"newer snapshot replaces older", 4, 0, []string{race1, start, race2}, []string{race2, start}, []bool{true, true, true}},
+		{"progress coalesced per player", 4, 0, []string{progressA, progressB, laterA}, []string{laterA, progressB}, []bool{true, true, true}},
+		{"coalescing needs no room", 2, 1, []string{race1, start, race2}, []string{race2, start}, []bool{true, true, true}},
+		{"oldest non-critical dropped", 2, 0, []string{start, joinA, joinB}, []string{start, joinB}, []bool{true, true, true}},
+		{"new message dropped behind critical ones", 2, 0, []string{start, end, joinA}, []string{start, end}, []bool{true, true, true}},
+		{"critical message never dropped", 2, 0, []string{start, end, start}, []string{start, end}, []bool{true, true, false}},
+		{"critical message makes room", 2, 0, []string{joinA, joinB, end}, []string{joinB, end}, []bool{true, true, true}},
+		{"too many dropped", 1, 2, []string{joinA, joinB, joinC}, []string{joinC}, []bool{true, true, false}},
+	}
+	for _, test := range tests {
+		box := newOutbox(test.limit, test.maxDropped)
+		var pushed []bool
+		for _, message := range test.messages {
+			pushed = append(pushed, box.push(newOutgoing([]byte(message))))
+		}
+		var queue []string
+		for _, message := range box.queue {
+			queue = append(queue, string(message.data))
+		}
+		if !reflect.DeepEqual(queue, test.wantQueue) {
+			t.Errorf("%s: queued %v, want %v", test.name, queue, test.wantQueue)
+		}
+		if !reflect.DeepEqual(pushed, test.wantPushed) {
+			t.Errorf("%s: push returned %v, want %v", test.name, pushed, test.wantPushed)
+		}
+	}
+}
+
+func TestOutboxDroppedResetsWhenEmpty(t *testing.T) {
+	box := newOutbox(1, 2)
+	box.push(newOutgoing([]byte(`{"type":"join","username":"a"}`)))
+	box.push(newOutgoing([]byte(`{"type":"join","username":"b"}`)))
+	// The client caught up, so earlier drops no longer count against it.
+	if _, ok := box.next(); !ok {
+		t.Fatal("closed outbox")
+	}
+	box.push(newOutgoing([]byte(`{"type":"join","username":"c"}`)))
+	if !box.push(newOutgoing([]byte(`{"type":"join","username":"d"}`))) {
+		t.Error("a client that caught up was treated as too slow")
+	}
+}
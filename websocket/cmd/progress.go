@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// racerProgress is one player's entry in a raceProgress snapshot.
+type racerProgress struct {
+	Userid     string  `json:"userid"`
+	Percentage int     `json:"percentage"`
+	WPM        float64 `json:"wpm"`
+	Position   int     `json:"position"`
+	Finished   bool    `json:"finished"`
+	Eliminated bool    `json:"eliminated,omitempty"` // sudden death
+}
+
+// reportProgress publishes the progress of userid. With a progress tick
+// configured it is collected into the next raceProgress snapshot of the
+// room, otherwise it goes out right away as a userProgress message.
+func (gs *GameServer) reportProgress(room string, userid string, percentage int, wpm float64) {
+	if gs.cfg.ProgressTick <= 0 {
+		gs.broadcastUserProgress(room, userid, percentage)
+		return
+	}
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+	game, exists := gs.games[room]
+	if !exists || !game.IsActive {
+		return
+	}
+	if entry, ok := game.progress[userid]; ok && entry.Eliminated {
+		return
+	}
+	game.progress[userid] = &racerProgress{Userid: userid, Percentage: percentage, WPM: wpm}
+	game.progressChanged = true
+	gs.hotLog.Debug("progress collected", "room", room, "user", userid, "percentage", percentage)
+}
+
+// runProgressTicker broadcasts the progress snapshot of game every
+// ProgressTick while something changed, until the race is over.
+func (gs *GameServer) runProgressTicker(room string, game *GameState) {
+	ticker := time.NewTicker(gs.cfg.ProgressTick)
+	defer ticker.Stop()
+	for range ticker.C {
+		gs.mutex.Lock()
+		running := game.IsActive && gs.games[room] == game
+		gs.mutex.Unlock()
+		if !running {
+			return
+		}
+		gs.flushProgress(room, game)
+	}
+}
+
+// flushProgress sends the snapshot of game if it changed since the last one,
+// and the team standings of a relay collected since the last tick.
+func (gs *GameServer) flushProgress(room string, game *GameState) {
+	var messages [][]byte
+	gs.mutex.Lock()
+	if game.progressChanged {
+		game.progressChanged = false
+		snapshot := struct {
+			Type    string           `json:"type"`
+			MatchId string           `json:"matchId"`
+			Players []*racerProgress `json:"players"`
+		}{
+			Type:    "raceProgress",
+			MatchId: game.MatchId,
+			Players: game.standingsLocked(),
+		}
+		messageBytes, _ := json.Marshal(snapshot)
+		messages = append(messages, messageBytes)
+	}
+	if game.teamProgress != nil {
+		messages = append(messages, teamProgressMessage(game.teamProgress))
+		game.teamProgress = nil
+	}
+	gs.mutex.Unlock()
+	for _, messageBytes := range messages {
+		gs.broadcastToRoom(room, messageBytes)
+	}
+}
+
+// standingsLocked orders the collected progress: finished players by their
+// finishing position, then everybody else by percentage and players out of a
+// sudden death race last. The caller must hold gs.mutex.
+func (game *GameState) standingsLocked() []*racerProgress {
+	finishedAt := make(map[string]int)
+	for position, client := range game.leaderBoard {
+		finishedAt[client.username], _ = strconv.Atoi(position)
+	}
+	players := make([]*racerProgress, 0, len(game.progress))
+	for _, entry := range game.progress {
+		copied := *entry
+		_, copied.Finished = finishedAt[copied.Userid]
+		players = append(players, &copied)
+	}
+	sort.Slice(players, func(i, j int) bool {
+		a, b := players[i], players[j]
+		if a.Finished != b.Finished {
+			return a.Finished
+		}
+		if a.Eliminated != b.Eliminated {
+			return b.Eliminated
+		}
+		if a.Finished {
+			return finishedAt[a.Userid] < finishedAt[b.Userid]
+		}
+		if a.Percentage != b.Percentage {
+			return a.Percentage > b.Percentage
+		}
+		return a.Userid < b.Userid
+	})
+	for i, player := range players {
+		player.Position = i + 1
+	}
+	return players
+}
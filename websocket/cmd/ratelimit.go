@@ -0,0 +1,108 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// RateLimit is a token bucket: Rate messages per second on average with
+// bursts of up to Burst messages.
+type RateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// defaultRateLimit is the key of Config.RateLimits used for message types
+// that have no limit of their own.
+const defaultRateLimit = "default"
+
+// throttleForgiveAfter is how long a client has to stay within its limits
+// before earlier violations are forgotten.
+const throttleForgiveAfter = 10 * time.Second
+
+type tokenBucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) take(now time.Time) bool {
+	b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+	if b.tokens > float64(b.limit.Burst) {
+		b.tokens = float64(b.limit.Burst)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// rateLimiter keeps one bucket per message type for a client. It is only used
+// from the client's readPump, so it needs no locking.
+type rateLimiter struct {
+	limits        map[string]RateLimit
+	buckets       map[string]*tokenBucket
+	strikes       int // messages dropped since the client last behaved
+	lastThrottled time.Time
+}
+
+func newRateLimiter(limits map[string]RateLimit) *rateLimiter {
+	return &rateLimiter{limits: limits, buckets: make(map[string]*tokenBucket)}
+}
+
+// allow takes a token for messageType. key is the limit that was applied.
+func (l *rateLimiter) allow(messageType string, now time.Time) (key string, allowed bool) {
+	key = messageType
+	limit, ok := l.limits[key]
+	if !ok {
+		key = defaultRateLimit
+		limit = l.limits[key]
+	}
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{limit: limit, tokens: float64(limit.Burst), last: now}
+		l.buckets[key] = bucket
+	}
+	if bucket.take(now) {
+		return key, true
+	}
+	if now.Sub(l.lastThrottled) > throttleForgiveAfter {
+		l.strikes = 0
+	}
+	l.strikes++
+	l.lastThrottled = now
+	return key, false
+}
+
+// allowMessage applies the rate limits to a message from client. Throttled
+// messages are dropped; a client that keeps flooding is first warned with an
+// error message and then disconnected.
+func (gs *GameServer) allowMessage(client *Client, messageType string) bool {
+	if client.limiter == nil {
+		return true
+	}
+	key, allowed := client.limiter.allow(messageType, time.Now())
+	if allowed {
+		return true
+	}
+	messagesThrottled.WithLabelValues(key).Inc()
+	strikes := client.limiter.strikes
+	switch {
+	case gs.cfg.ThrottleDisconnectAfter > 0 && strikes == gs.cfg.ThrottleDisconnectAfter:
+		clientsThrottled.WithLabelValues("disconnect").Inc()
+		gs.logFor(client).Warn("rate limit exceeded, disconnecting client", "type", key, "dropped", strikes)
+		closeMessage := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "rate limit exceeded")
+		client.conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second))
+		client.conn.Close()
+	case strikes == gs.cfg.ThrottleWarnAfter:
+		clientsThrottled.WithLabelValues("warn").Inc()
+		gs.logFor(client).Warn("rate limit exceeded", "type", key, "dropped", strikes)
+		gs.sendError(client, "too many messages, slow down")
+	default:
+		gs.hotLogFor(client).Debug("message throttled", "type", key)
+	}
+	return false
+}
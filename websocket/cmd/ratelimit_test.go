@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Now()
+	bucket := &tokenBucket{limit: RateLimit{Rate: 2, Burst: 3}, tokens: 3, last: start}
+	tests := []struct {
+		name string
+		at   time.Duration
+		want bool
+	}{
+		{"burst 1", 0, true},
+		{"burst 2", 0, true},
+		{"burst 3", 0, true},
+		{"burst spent", 0, false},
+		{"half a token", 250 * time.Millisecond, false},
+		{"refilled a token", 500 * time.Millisecond, true},
+		{"token spent", 500 * time.Millisecond, false},
+		// A long pause refills no more than the burst.
+		{"after a pause 1", 10 * time.Second, true},
+		{"after a pause 2", 10 * time.Second, true},
+		{"after a pause 3", 10 * time.Second, true},
+		{"after a pause 4", 10 * time.Second, false},
+	}
+	for _, test := range tests {
+		if got := bucket.take(start.Add(test.at)); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	start := time.Now()
+	limiter := newRateLimiter(map[string]RateLimit{
+		"wordComplete":   {Rate: 1, Burst: 2},
+		defaultRateLimit: {Rate: 1, Burst: 1},
+	})
+	tests := []struct {
+		name        string
+		messageType string
+		at          time.Duration
+		wantKey     string
+		wantAllowed bool
+		wantStrikes int
+	}{
+		{"own limit", "wordComplete", 0, "wordComplete", true, 0},
+		{"own burst", "wordComplete", 0, "wordComplete", true, 0},
+		{"own limit spent", "wordComplete", 0, "wordComplete", false, 1},
+		{"default limit", "join", 0, defaultRateLimit, true, 1},
+		// Types without a limit of their own share the default bucket.
+		{"default shared", "ready", 0, defaultRateLimit, false, 2},
+		{"refilled", "wordComplete", time.Second, "wordComplete", true, 2},
+		{"refilled again", "wordComplete", 2 * time.Second, "wordComplete", true, 2},
+		{"strikes add up", "wordComplete", 2 * time.Second, "wordComplete", false, 3},
+		// Behaving for long enough forgives earlier strikes.
+		{"behaved", "join", 20 * time.Second, defaultRateLimit, true, 3},
+		{"forgiven", "join", 20 * time.Second, defaultRateLimit, false, 1},
+	}
+	for _, test := range tests {
+		key, allowed := limiter.allow(test.messageType, start.Add(test.at))
+		if key != test.wantKey || allowed != test.wantAllowed || limiter.strikes != test.wantStrikes {
+			t.Errorf("%s: got %s, %v with %d strikes, want %s, %v with %d strikes", test.name, key, allowed, limiter.strikes, test.wantKey, test.wantAllowed, test.wantStrikes)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"golang.org/x/exp/rand"
+)
+
+// relayTeam is one team of a relay race. Its members run the legs of the
+// text in turn, a member runs several legs when the team is short-handed.
+type relayTeam struct {
+	Name     string   `json:"name"`
+	Members  []string `json:"members"`
+	members  []*Client
+	legs     []*PlayerWordRecord // one per leg, in running order
+	leg      int                 // index of the leg being run
+	position int                 // finishing position, 0 while running
+}
+
+func (team *relayTeam) runner() *Client {
+	return team.members[team.leg%len(team.members)]
+}
+
+// completed is the number of words the team typed so far.
+func (team *relayTeam) completed() int {
+	completed := 0
+	for _, record := range team.legs {
+		completed += len(record.wordTimes)
+	}
+	return completed
+}
+
+// relayState is the part of a GameState only relay races have.
+type relayState struct {
+	legs     [][]string
+	teams    []*relayTeam
+	byClient map[string]*relayTeam
+	finished int
+}
+
+// splitLegs divides words into count legs of nearly the same length.
+func splitLegs(words []string, count int) [][]string {
+	legs := make([][]string, count)
+	for i := range legs {
+		legs[i] = words[i*len(words)/count : (i+1)*len(words)/count]
+	}
+	return legs
+}
+
+// setupRelay splits the players of a race that is about to start into teams
+// and hands the first leg to the first member of every team. The other
+// members get no words until it is their turn. It runs before the game is
+// published, so every word that arrives already finds the relay.
+func setupRelay(game *GameState, teamCount int) {
+	players := make([]*Client, 0, len(game.InGameUsers))
+	for _, client := range game.InGameUsers {
+		players = append(players, client)
+	}
+	if len(players) == 0 {
+		return
+	}
+	rand.Shuffle(len(players), func(i, j int) { players[i], players[j] = players[j], players[i] })
+	teamCount = min(teamCount, len(players))
+	legCount := min((len(players)+teamCount-1)/teamCount, len(game.wordList))
+
+	relay := &relayState{
+		legs:     splitLegs(game.wordList, legCount),
+		byClient: make(map[string]*relayTeam),
+	}
+	for i := 0; i < teamCount; i++ {
+		relay.teams = append(relay.teams, &relayTeam{Name: fmt.Sprintf("team%d", i+1)})
+	}
+	for i, client := range players {
+		team := relay.teams[i%teamCount]
+		team.members = append(team.members, client)
+		team.Members = append(team.Members, client.username)
+		relay.byClient[client.id] = team
+		game.PlayerProgress[client.id] = &PlayerWordRecord{username: client.username}
+	}
+	for _, team := range relay.teams {
+		for i, words := range relay.legs {
+			team.legs = append(team.legs, &PlayerWordRecord{
+				username:      team.members[i%len(team.members)].username,
+				remainedWords: words,
+			})
+		}
+		game.PlayerProgress[team.runner().id] = team.legs[0]
+	}
+	game.relay = relay
+}
+
+// startRelay tells the room the teams and legs of a relay race that just
+// started and who runs the first leg of every team.
+func (gs *GameServer) startRelay(room string, game *GameState) {
+	relay := game.relay
+	if relay == nil {
+		return
+	}
+	legLengths := make([]int, len(relay.legs))
+	for i, words := range relay.legs {
+		legLengths[i] = len(words)
+	}
+	relayMessage := struct {
+		Type    string       `json:"type"`
+		MatchId string       `json:"matchId"`
+		Teams   []*relayTeam `json:"teams"`
+		Legs    []int        `json:"legs"` // words per leg
+	}{
+		Type:    "relayStart",
+		MatchId: game.MatchId,
+		Teams:   relay.teams,
+		Legs:    legLengths,
+	}
+	messageBytes, _ := json.Marshal(relayMessage)
+	gs.broadcastToRoom(room, messageBytes)
+	gs.log.Info("relay started", "room", room, "match", game.MatchId, "teams", len(relay.teams), "legs", len(relay.legs))
+	for _, team := range relay.teams {
+		gs.announceLeg(room, game, team)
+	}
+}
+
+// announceLeg tells the room who runs the current leg of team, which
+// unlocks the input of that player.
+func (gs *GameServer) announceLeg(room string, game *GameState, team *relayTeam) {
+	from := 0
+	for _, words := range game.relay.legs[:team.leg] {
+		from += len(words)
+	}
+	legMessage := struct {
+		Type   string   `json:"type"`
+		Team   string   `json:"team"`
+		Leg    int      `json:"leg"`
+		Runner string   `json:"runner"`
+		From   int      `json:"from"` // index of the first word of the leg
+		Words  []string `json:"words"`
+	}{
+		Type:   "relayLeg",
+		Team:   team.Name,
+		Leg:    team.leg + 1,
+		Runner: team.runner().username,
+		From:   from,
+		Words:  game.relay.legs[team.leg],
+	}
+	messageBytes, _ := json.Marshal(legMessage)
+	gs.broadcastToRoom(room, messageBytes)
+}
+
+// relayWordComplete is wordComplete for relay races. Only the runner of the
+// current leg of a team may type, finishing a leg hands over to the next
+// member and finishing the last leg ranks the team.
+func (gs *GameServer) relayWordComplete(client *Client, game *GameState, word string) {
+	team, ok := game.relay.byClient[client.id]
+	if !ok || team.position > 0 || team.runner() != client {
+		gs.hotLogFor(client).Debug("word outside of own leg ignored")
+		return
+	}
+	record := team.legs[team.leg]
+	if len(record.remainedWords) == 0 || !game.sameWord(record.remainedWords[0], word) {
+		gs.hotLogFor(client).Debug("word does not match", "got", word)
+		return
+	}
+	record.remainedWords = record.remainedWords[1:]
+	record.wordTimes = append(record.wordTimes, time.Now().UTC().UnixMilli()-game.StartTime)
+	if len(record.remainedWords) > 0 {
+		gs.reportTeamProgress(client.room, game)
+		return
+	}
+
+	// Handovers and finishes change who may type, they don't wait for the
+	// next tick.
+	gs.broadcastTeamProgress(client.room, game)
+	if team.leg+1 < len(team.legs) {
+		team.leg++
+		game.PlayerProgress[team.runner().id] = team.legs[team.leg]
+		gs.logFor(client).Info("relay leg finished", "team", team.Name, "leg", team.leg)
+		gs.announceLeg(client.room, game, team)
+		return
+	}
+	game.relay.finished++
+	team.position = game.relay.finished
+	gs.logFor(client).Info("relay team finished", "team", team.Name, "position", team.position)
+	finishedMessage := struct {
+		Type     string `json:"type"`
+		Team     string `json:"team"`
+		Position int    `json:"position"`
+		Winner   bool   `json:"winner"`
+		Time     int64  `json:"time"` // ms after the race start
+	}{
+		Type:     "teamFinished",
+		Team:     team.Name,
+		Position: team.position,
+		Winner:   team.position == 1,
+		Time:     record.wordTimes[len(record.wordTimes)-1],
+	}
+	messageBytes, _ := json.Marshal(finishedMessage)
+	gs.broadcastToRoom(client.room, messageBytes)
+
+	if game.relay.finished == len(game.relay.teams) {
+		gs.closeMatch(client.room, "finished")
+		endGameMessage, _ := json.Marshal(&GameMessage{Type: "endGame"})
+		gs.broadcastToRoom(client.room, endGameMessage)
+	} else if team.position == 1 {
+		go gs.runEndTimer(client.room, game.MatchId)
+	}
+}
+
+// teamStanding is one team's entry in a teamProgress message.
+type teamStanding struct {
+	Team       string `json:"team"`
+	Percentage int    `json:"percentage"`
+	Leg        int    `json:"leg"`
+	Runner     string `json:"runner"`
+	Position   int    `json:"position,omitempty"`
+	team       *relayTeam
+}
+
+// reportTeamProgress publishes the standings of the teams of game. Like
+// reportProgress it collects them into the next progress tick when one is
+// configured.
+func (gs *GameServer) reportTeamProgress(room string, game *GameState) {
+	if gs.cfg.ProgressTick <= 0 {
+		gs.broadcastTeamProgress(room, game)
+		return
+	}
+	teams := game.relay.standings(game.TotalWords)
+	gs.mutex.Lock()
+	game.teamProgress = teams
+	gs.mutex.Unlock()
+}
+
+// broadcastTeamProgress sends the standings of the teams of game right away,
+// replacing the ones collected for the next tick.
+func (gs *GameServer) broadcastTeamProgress(room string, game *GameState) {
+	teams := game.relay.standings(game.TotalWords)
+	gs.mutex.Lock()
+	game.teamProgress = nil
+	gs.mutex.Unlock()
+	gs.broadcastToRoom(room, teamProgressMessage(teams))
+}
+
+func teamProgressMessage(teams []*teamStanding) []byte {
+	progressMessage := struct {
+		Type  string          `json:"type"`
+		Teams []*teamStanding `json:"teams"`
+	}{
+		Type:  "teamProgress",
+		Teams: teams,
+	}
+	messageBytes, _ := json.Marshal(progressMessage)
+	return messageBytes
+}
+
+// standings orders the teams: finished ones by position, then the others by
+// how far they got.
+func (relay *relayState) standings(totalWords int) []*teamStanding {
+	teams := make([]*teamStanding, 0, len(relay.teams))
+	for _, team := range relay.teams {
+		percentage := 0
+		if totalWords > 0 {
+			percentage = team.completed() * 100 / totalWords
+		}
+		teams = append(teams, &teamStanding{
+			Team:       team.Name,
+			Percentage: percentage,
+			Leg:        team.leg + 1,
+			Runner:     team.runner().username,
+			Position:   team.position,
+			team:       team,
+		})
+	}
+	sort.SliceStable(teams, func(i, j int) bool {
+		a, b := teams[i], teams[j]
+		if (a.Position > 0) != (b.Position > 0) {
+			return a.Position > 0
+		}
+		if a.Position > 0 {
+			return a.Position < b.Position
+		}
+		return a.Percentage > b.Percentage
+	})
+	return teams
+}
+
+// matchStandings lists every team member with the position and progress of
+// its team for the match history.
+func (relay *relayState) matchStandings(totalWords int) []MatchStanding {
+	var standings []MatchStanding
+	for _, team := range relay.standings(totalWords) {
+		for _, member := range team.team.members {
+			standings = append(standings, MatchStanding{
+				Username: member.username,
+				Team:     team.Team,
+				Position: team.Position,
+				Progress: team.Percentage,
+				Bot:      member.isBot,
+			})
+		}
+	}
+	return standings
+}
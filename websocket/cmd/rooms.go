@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Race modes a room can be set to.
+const (
+	modeClassic     = "classic"
+	modeRelay       = "relay"
+	modeElimination = "elimination"
+	modeCode        = "code"
+)
+
+var roomModes = map[string]bool{
+	modeClassic:     true,
+	modeRelay:       true,
+	modeElimination: true,
+	modeCode:        true,
+}
+
+const maxRelayTeams = 8
+
+// RoomSettings are the per-room options. Rooms listed in the config start out
+// with the settings given there, every other room uses the zero value.
+type RoomSettings struct {
+	// RequireAuth only lets clients with a verified auth token join.
+	RequireAuth bool `yaml:"requireAuth" json:"requireAuth"`
+	// Mode is the kind of race the room runs, classic when empty.
+	Mode string `yaml:"mode" json:"mode,omitempty"`
+	// Teams is the number of teams relay races are split into, 2 when 0.
+	Teams int `yaml:"teams" json:"teams,omitempty"`
+	// Eliminate is the number of players knocked out after every round of
+	// an elimination, 1 when 0.
+	Eliminate int `yaml:"eliminate" json:"eliminate,omitempty"`
+	// SuddenDeath ends the race of a player at the first wrong word. It
+	// applies to classic and elimination races.
+	SuddenDeath bool `yaml:"suddenDeath" json:"suddenDeath,omitempty"`
+	// CodeLanguage picks the snippets directory code races use, any when
+	// empty.
+	CodeLanguage string `yaml:"codeLanguage" json:"codeLanguage,omitempty"`
+	// Language is the code of the language the room races in, the default
+	// language when empty.
+	Language string `yaml:"language" json:"language,omitempty"`
+	// Source is where generated texts come from, random words when empty.
+	// corpus:<name> races on the texts saved to a custom corpus.
+	Source string `yaml:"source" json:"source,omitempty"`
+	// Difficulty applies to texts of random words.
+	Difficulty Difficulty `yaml:"difficulty" json:"difficulty"`
+	// Seed makes every race of the room use the same text, a new one is
+	// picked for every race when 0. Rooms with the same settings and seed
+	// race on the same text.
+	Seed uint64 `yaml:"seed" json:"seed,omitempty"`
+}
+
+func (s RoomSettings) validate() error {
+	if s.Mode != "" && !roomModes[s.Mode] {
+		return fmt.Errorf("unknown mode %q", s.Mode)
+	}
+	if s.Teams < 0 || s.Teams == 1 || s.Teams > maxRelayTeams {
+		return fmt.Errorf("teams must be between 2 and %d", maxRelayTeams)
+	}
+	if s.Eliminate < 0 {
+		return errors.New("eliminate must not be negative")
+	}
+	if s.SuddenDeath && s.Mode == modeRelay {
+		return errors.New("suddenDeath is not available in relay rooms")
+	}
+	if s.CodeLanguage != "" && !codeLanguagePattern.MatchString(s.CodeLanguage) {
+		return fmt.Errorf("codeLanguage %q is not a snippets directory name", s.CodeLanguage)
+	}
+	if name, ok := corpusSource(s.Source); ok {
+		if err := validCorpusName(name); err != nil {
+			return err
+		}
+	} else if s.Source != "" && !textSources[s.Source] {
+		return fmt.Errorf("unknown source %q", s.Source)
+	}
+	if s.Seed > maxSeed {
+		return fmt.Errorf("seed must be at most %d", uint64(maxSeed))
+	}
+	return nil
+}
+
+func (s RoomSettings) mode() string {
+	if s.Mode == "" {
+		return modeClassic
+	}
+	return s.Mode
+}
+
+func (s RoomSettings) source() string {
+	if s.Source == "" {
+		return sourceWords
+	}
+	return s.Source
+}
+
+func (s RoomSettings) teams() int {
+	if s.Teams == 0 {
+		return 2
+	}
+	return s.Teams
+}
+
+func (s RoomSettings) eliminate() int {
+	if s.Eliminate == 0 {
+		return 1
+	}
+	return s.Eliminate
+}
+
+// roomSettingsLocked returns the settings of room, creating default ones for rooms
+// that were not configured. The caller must hold gs.mutex.
+func (gs *GameServer) roomSettingsLocked(room string) *RoomSettings {
+	settings, ok := gs.settings[room]
+	if !ok {
+		settings = &RoomSettings{}
+		gs.settings[room] = settings
+	}
+	return settings
+}
+
+// roomSettings returns a copy of the settings of room.
+func (gs *GameServer) roomSettings(room string) RoomSettings {
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+	return *gs.roomSettingsLocked(room)
+}
+
+// requiresAuth reports whether only clients with a verified auth token may
+// join room. Besides rooms set to RequireAuth this holds for tournament
+// heats, which admit and score their players by username.
+func (gs *GameServer) requiresAuth(room string) bool {
+	return gs.roomSettings(room).RequireAuth || gs.isHeatRoom(room)
+}
+
+// roomHostLocked returns the host of room: the first player that joined it,
+// and once the host left the remaining human with the lowest id. Bots never
+// host. The caller must hold gs.mutex.
+func (gs *GameServer) roomHostLocked(room string) *Client {
+	members := gs.rooms[room]
+	if host, ok := members[gs.hosts[room]]; ok {
+		return host
+	}
+	var host *Client
+	for id, member := range members {
+		if !member.isBot && (host == nil || id < host.id) {
+			host = member
+		}
+	}
+	if host == nil {
+		delete(gs.hosts, room)
+		return nil
+	}
+	gs.hosts[room] = host.id
+	return host
+}
+
+// updateRoomSettings lets the host of a room pick its race mode between
+// races, e.g. {"mode": "relay", "teams": 3} or {"mode": "elimination",
+// "eliminate": 2, "suddenDeath": true}. Only the settings in the message
+// change, the others keep their value; {"seed": 0} goes back to a new text
+// for every race. Whether the room requires auth is only set in the config.
+func (gs *GameServer) updateRoomSettings(client *Client, messageContent json.RawMessage) {
+	if client.room == "" {
+		gs.sendError(client, "join a room before changing its settings")
+		return
+	}
+	if gs.isHeatRoom(client.room) {
+		gs.sendError(client, "tournament heats can't be changed")
+		return
+	}
+	if game, exists := gs.games[client.room]; exists && game.IsActive {
+		gs.sendError(client, "settings can only be changed between races")
+		return
+	}
+
+	gs.mutex.Lock()
+	if gs.roomHostLocked(client.room) != client {
+		gs.mutex.Unlock()
+		gs.sendError(client, "only the host of the room can change its settings")
+		return
+	}
+	settings := gs.roomSettingsLocked(client.room)
+	updated := *settings
+	if err := json.Unmarshal(messageContent, &updated); err != nil {
+		gs.mutex.Unlock()
+		gs.sendError(client, "invalid room settings")
+		return
+	}
+	updated.RequireAuth = settings.RequireAuth
+	err := updated.validate()
+	if _, ok := gs.cfg.Languages[updated.Language]; err == nil && updated.Language != "" && !ok {
+		err = fmt.Errorf("unknown language %q", updated.Language)
+	}
+	if err != nil {
+		gs.mutex.Unlock()
+		gs.sendError(client, err.Error())
+		return
+	}
+	// A pending custom text was split into words or lines for the old mode
+	// and language, it has to be submitted again.
+	dropped := false
+	if custom, ok := gs.customTexts[client.room]; ok && (custom.mode != updated.mode() || updated.Language != settings.Language || updated.CodeLanguage != settings.CodeLanguage) {
+		delete(gs.customTexts, client.room)
+		dropped = true
+	}
+	*settings = updated
+	gs.mutex.Unlock()
+	gs.logFor(client).Info("room settings changed", "mode", updated.mode(), "teams", updated.Teams, "eliminate", updated.Eliminate, "suddenDeath", updated.SuddenDeath, "codeLanguage", updated.CodeLanguage, "language", updated.Language, "source", updated.source(), "difficulty", updated.Difficulty.key(), "seed", updated.Seed)
+
+	settingsMessage := struct {
+		Type     string       `json:"type"`
+		Room     string       `json:"room"`
+		Settings RoomSettings `json:"settings"`
+	}{
+		Type:     "roomSettings",
+		Room:     client.room,
+		Settings: updated,
+	}
+	messageBytes, _ := json.Marshal(settingsMessage)
+	gs.broadcastToRoom(client.room, messageBytes)
+	if dropped {
+		errorMessage, _ := json.Marshal(map[string]string{"type": "error", "message": "the submitted text does not fit the new settings, submit it again"})
+		gs.broadcastToRoom(client.room, errorMessage)
+	}
+}
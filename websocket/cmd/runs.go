@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// RaceRun is the recorded timeline of one player's finished race.
+type RaceRun struct {
+	MatchId    string   `json:"matchId"`
+	Username   string   `json:"username"`
+	Text       string   `json:"text"`
+	Words      []string `json:"words"`
+	Language   string   `json:"language"`
+	Difficulty string   `json:"difficulty,omitempty"` // Difficulty.key, empty for plain words
+	Offsets    []int64  `json:"offsets"`              // ms after the race start at which each word was completed
+	FinishedAt int64    `json:"finishedAt"`
+}
+
+// Duration is the time in ms the player needed to finish the text.
+func (r *RaceRun) Duration() int64 {
+	if len(r.Offsets) == 0 {
+		return 0
+	}
+	return r.Offsets[len(r.Offsets)-1]
+}
+
+// WPM uses the usual five characters per word convention.
+func (r *RaceRun) WPM() float64 {
+	return wordsPerMinute(utf8.RuneCountInString(r.Text), r.Duration())
+}
+
+func wordsPerMinute(chars int, durationMs int64) float64 {
+	if durationMs <= 0 {
+		return 0
+	}
+	return (float64(chars) / 5) / (float64(durationMs) / 60000)
+}
+
+// MatchResult is the outcome of one race as written to the match history.
+type MatchResult struct {
+	MatchId  string `json:"matchId"`
+	Room     string `json:"room"`
+	Text     string `json:"text"`
+	Language string `json:"language"`
+	// Difficulty is Difficulty.key, empty for plain words.
+	Difficulty string `json:"difficulty,omitempty"`
+	// Seed the text was generated from, 0 for custom texts and ghost races.
+	Seed      uint64 `json:"seed,omitempty"`
+	StartTime int64  `json:"startTime"`
+	EndTime   int64  `json:"endTime"`
+	Reason    string `json:"reason"` // finished, timeout, shutdown or moved
+	Mode      string `json:"mode"`
+	// SuddenDeath is set when a wrong word ended a player's race.
+	SuddenDeath bool            `json:"suddenDeath,omitempty"`
+	Standings   []MatchStanding `json:"standings"`
+}
+
+type MatchStanding struct {
+	Username string `json:"username"`
+	Position int    `json:"position,omitempty"` // 0 for players that did not finish
+	Progress int    `json:"progress"`
+	Bot      bool   `json:"bot,omitempty"`
+	Team     string `json:"team,omitempty"` // relay races only
+	// Eliminated is set for players out of a sudden death race.
+	Eliminated bool `json:"eliminated,omitempty"`
+}
+
+// RunStore keeps the finished race timelines so they can be raced against
+// later, and the match history. With a directory set both are appended to
+// JSON lines files in it and the runs are loaded again on startup.
+type RunStore struct {
+	mutex sync.Mutex
+	runs  []*RaceRun
+	dir   string
+}
+
+const (
+	runsFile    = "runs.jsonl"
+	matchesFile = "matches.jsonl"
+)
+
+func NewRunStore(dir string) (*RunStore, error) {
+	rs := &RunStore{dir: dir}
+	if dir == "" {
+		return rs, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filepath.Join(dir, runsFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return rs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(file)
+	for {
+		var run RaceRun
+		if err := decoder.Decode(&run); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", runsFile, err)
+		}
+		rs.runs = append(rs.runs, &run)
+	}
+	return rs, nil
+}
+
+func (rs *RunStore) Record(run *RaceRun) error {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	rs.runs = append(rs.runs, run)
+	return rs.appendLocked(runsFile, run)
+}
+
+func (rs *RunStore) RecordMatch(result *MatchResult) error {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	return rs.appendLocked(matchesFile, result)
+}
+
+func (rs *RunStore) appendLocked(name string, value any) error {
+	if rs.dir == "" {
+		return nil
+	}
+	line, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filepath.Join(rs.dir, name), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Best returns the fastest run of username in the given language and
+// difficulty, or the fastest run of anybody when username has not finished
+// such a race yet. Runs of other difficulties are never compared.
+func (rs *RunStore) Best(username string, language string, difficulty string) *RaceRun {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	var personal, top *RaceRun
+	for _, run := range rs.runs {
+		if run.Language != language || run.Difficulty != difficulty || run.Duration() <= 0 {
+			continue
+		}
+		if top == nil || run.WPM() > top.WPM() {
+			top = run
+		}
+		if run.Username == username && (personal == nil || run.WPM() > personal.WPM()) {
+			personal = run
+		}
+	}
+	if personal != nil {
+		return personal
+	}
+	return top
+}
+
+// recordRun stores the timeline of a client that just finished the race in its
+// room. Bots are left out so they never show up as personal bests.
+func (gs *GameServer) recordRun(client *Client) {
+	if client.isBot || gs.games[client.room].relay != nil {
+		return
+	}
+	game := gs.games[client.room]
+	record, ok := game.PlayerProgress[client.id]
+	if !ok || len(record.wordTimes) != game.TotalWords {
+		return
+	}
+	err := gs.runs.Record(&RaceRun{
+		MatchId:    game.MatchId,
+		Username:   client.username,
+		Text:       game.Text,
+		Words:      game.wordList,
+		Language:   game.language,
+		Difficulty: game.difficulty.key(),
+		Offsets:    append([]int64(nil), record.wordTimes...),
+		FinishedAt: time.Now().UTC().UnixMilli(),
+	})
+	if err != nil {
+		gs.logFor(client).Error("saving run failed", "err", err)
+	}
+}
+
+// closeMatch ends the active race of room and writes its result to the match
+// history. reason is finished, timeout, shutdown or moved.
+func (gs *GameServer) closeMatch(room string, reason string) {
+	game := gs.games[room]
+	gs.flushProgress(room, game)
+	game.IsActive = false
+	racesFinished.Inc()
+	defer gs.tournamentRaceEnded(room, game)
+	defer gs.eliminationRaceEnded(room, game, reason)
+
+	result := &MatchResult{
+		MatchId:     game.MatchId,
+		Room:        room,
+		Text:        game.Text,
+		Language:    game.language,
+		Difficulty:  game.difficulty.key(),
+		Seed:        game.seed,
+		StartTime:   game.StartTime,
+		EndTime:     time.Now().UTC().UnixMilli(),
+		Reason:      reason,
+		Mode:        game.mode,
+		SuddenDeath: game.suddenDeath,
+	}
+	if game.relay != nil {
+		result.Standings = game.relay.matchStandings(game.TotalWords)
+	}
+	finished := make(map[string]bool)
+	for position := 1; position <= len(game.leaderBoard); position++ {
+		client, ok := game.leaderBoard[strconv.Itoa(position)]
+		if !ok {
+			continue
+		}
+		finished[client.id] = true
+		result.Standings = append(result.Standings, MatchStanding{Username: client.username, Position: position, Progress: 100, Bot: client.isBot})
+	}
+	var unfinished []MatchStanding
+	for id, client := range game.InGameUsers {
+		if finished[id] || game.relay != nil {
+			continue
+		}
+		progress := 0
+		if record, ok := game.PlayerProgress[id]; ok && game.TotalWords > 0 {
+			progress = (game.TotalWords - len(record.remainedWords)) * 100 / game.TotalWords
+		}
+		_, failed := game.failed[id]
+		unfinished = append(unfinished, MatchStanding{Username: client.username, Progress: progress, Bot: client.isBot, Eliminated: failed})
+	}
+	// Players out of a sudden death race rank below everybody still typing.
+	sort.Slice(unfinished, func(i, j int) bool {
+		if unfinished[i].Eliminated != unfinished[j].Eliminated {
+			return unfinished[j].Eliminated
+		}
+		return unfinished[i].Progress > unfinished[j].Progress
+	})
+	result.Standings = append(result.Standings, unfinished...)
+
+	gs.log.Info("race ended", "room", room, "match", game.MatchId, "reason", reason, "finishers", len(game.leaderBoard))
+	if err := gs.runs.RecordMatch(result); err != nil {
+		gs.log.Error("saving match result failed", "room", room, "match", game.MatchId, "err", err)
+	}
+}
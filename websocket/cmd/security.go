@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// originPattern is one entry of Config.AllowedOrigins.
+type originPattern struct {
+	any    bool
+	scheme string // empty matches http and https
+	host   string // a leading "*." matches any subdomain
+	port   string // empty matches any port
+}
+
+func parseOriginPattern(pattern string) (originPattern, error) {
+	if pattern == "*" {
+		return originPattern{any: true}, nil
+	}
+	var parsed originPattern
+	rest := pattern
+	if scheme, after, found := strings.Cut(rest, "://"); found {
+		parsed.scheme = strings.ToLower(scheme)
+		rest = after
+	}
+	if host, port, err := net.SplitHostPort(rest); err == nil {
+		parsed.host, parsed.port = host, port
+	} else {
+		parsed.host = rest
+	}
+	parsed.host = strings.ToLower(parsed.host)
+	wildcard := strings.TrimPrefix(parsed.host, "*.")
+	if wildcard == "" || strings.ContainsAny(wildcard, "*/") {
+		return originPattern{}, fmt.Errorf("allowed origin %q is not a valid pattern", pattern)
+	}
+	return parsed, nil
+}
+
+func (p originPattern) matches(origin *url.URL) bool {
+	if p.any {
+		return true
+	}
+	if p.scheme != "" && p.scheme != strings.ToLower(origin.Scheme) {
+		return false
+	}
+	if p.port != "" && p.port != origin.Port() {
+		return false
+	}
+	host := strings.ToLower(origin.Hostname())
+	if suffix, ok := strings.CutPrefix(p.host, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return host == p.host
+}
+
+// checkOrigin accepts requests without an Origin header (non-browser clients)
+// and browser requests from one of the allowed origins.
+func (gs *GameServer) checkOrigin(r *http.Request) bool {
+	header := r.Header.Get("Origin")
+	if header == "" || len(gs.cfg.AllowedOrigins) == 0 {
+		return true
+	}
+	origin, err := url.Parse(header)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range gs.cfg.AllowedOrigins {
+		pattern, err := parseOriginPattern(allowed)
+		if err == nil && pattern.matches(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP is the address connection limits are counted against.
+func (gs *GameServer) clientIP(r *http.Request) string {
+	if gs.cfg.TrustProxyHeaders {
+		if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// acquireIP counts a new connection from ip, or reports false when ip is
+// already at its limit.
+func (gs *GameServer) acquireIP(ip string) bool {
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+	if gs.cfg.MaxConnectionsPerIP > 0 && gs.ipConnections[ip] >= gs.cfg.MaxConnectionsPerIP {
+		return false
+	}
+	gs.ipConnections[ip]++
+	return true
+}
+
+func (gs *GameServer) releaseIP(ip string) {
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+	gs.ipConnections[ip]--
+	if gs.ipConnections[ip] <= 0 {
+		delete(gs.ipConnections, ip)
+	}
+}
+
+// identify reads the username from the auth_token or nickname subprotocol.
+// authenticated is only true for a token the auth API accepted.
+func (gs *GameServer) identify(r *http.Request) (username string, authenticated bool) {
+	for _, protocol := range websocket.Subprotocols(r) {
+		if strings.HasPrefix(protocol, "auth_token:") && gs.cfg.APIURL != "" {
+			token := strings.TrimPrefix(protocol, "auth_token:")
+			auth, err := verifyTokenWithFastAPI(gs.cfg.APIURL, token)
+			if err != nil || !auth.Valid {
+				authFailures.Inc()
+				gs.log.Warn("auth token rejected", "remote", r.RemoteAddr, "err", err)
+			} else {
+				username, authenticated = auth.UserName, true
+			}
+		} else if strings.HasPrefix(protocol, "nickname:") {
+			if username == "" {
+				username = strings.TrimPrefix(protocol, "nickname:")
+			}
+		}
+	}
+	if username == "" {
+		username = fmt.Sprintf("Guest_%d", time.Now().UnixNano()%10000)
+	}
+	return username, authenticated
+}
+
+// rejectConnection answers a handshake that failed the connection policy.
+func (gs *GameServer) rejectConnection(w http.ResponseWriter, r *http.Request, status int, reason string) {
+	connectionsRejected.WithLabelValues(reason).Inc()
+	gs.log.Warn("connection rejected", "remote", r.RemoteAddr, "origin", r.Header.Get("Origin"), "reason", reason)
+	http.Error(w, http.StatusText(status), status)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Drain prepares the server for shutdown: new connections are refused, no new
+// races start, every client is told with a serverShutdown message when to
+// reconnect, and running races get until ctx is done to finish before they
+// are ended with reason shutdown and written to the match history.
+//
+// With snapshots enabled the races still running are saved to the snapshot
+// instead of being ended: the next start restores them with every player's
+// remaining words and the players resume them, and closeMatch writes their
+// result once they finish there. Only when saving that snapshot fails are
+// they ended here, so a race is never lost.
+func (gs *GameServer) Drain(ctx context.Context) {
+	gs.draining.Store(true)
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now()
+	}
+	shutdownMessage := struct {
+		Type           string `json:"type"`
+		ReconnectAfter int64  `json:"reconnectAfter"` // ms
+		Deadline       int64  `json:"deadline"`       // unix ms when running races are ended
+	}{
+		Type:           "serverShutdown",
+		ReconnectAfter: time.Until(deadline).Milliseconds() + gs.cfg.ReconnectDelay.Milliseconds(),
+		Deadline:       deadline.UTC().UnixMilli(),
+	}
+	messageBytes, _ := json.Marshal(shutdownMessage)
+	queued := newOutgoing(messageBytes)
+	gs.mutex.Lock()
+	for client := range gs.connections {
+		gs.sendOutgoing(client, queued)
+	}
+	gs.mutex.Unlock()
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+wait:
+	for gs.activeRooms() != nil {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			break wait
+		}
+	}
+
+	if gs.snapshotsEnabled() && gs.activeRooms() != nil {
+		err := gs.SaveSnapshot()
+		if err == nil {
+			gs.log.Info("running races kept in the snapshot", "rooms", gs.activeRooms())
+			gs.waitFlushed(2 * time.Second)
+			return
+		}
+		gs.log.Error("saving snapshot failed, ending running races", "err", err)
+	}
+	endGameMessage, _ := json.Marshal(&GameMessage{Type: "endGame"})
+	for _, room := range gs.activeRooms() {
+		gs.closeMatch(room, "shutdown")
+		gs.broadcastToRoom(room, endGameMessage)
+	}
+	gs.waitFlushed(2 * time.Second)
+}
+
+// refuseWhileDraining tells whether a race in room must not start because
+// the server is shutting down.
+func (gs *GameServer) refuseWhileDraining(room string) bool {
+	if !gs.draining.Load() {
+		return false
+	}
+	gs.log.Info("race not started, server is draining", "room", room)
+	return true
+}
+
+// waitFlushed gives the write pumps up to timeout to send what is still queued.
+func (gs *GameServer) waitFlushed(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		queued := 0
+		gs.mutex.Lock()
+		for client := range gs.connections {
+			queued += client.outbox.len()
+		}
+		gs.mutex.Unlock()
+		if queued == 0 {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// activeRooms lists the rooms with a race in progress.
+func (gs *GameServer) activeRooms() []string {
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+	var rooms []string
+	for room, game := range gs.games {
+		if game.IsActive {
+			rooms = append(rooms, room)
+		}
+	}
+	return rooms
+}
+
+// CloseConnections sends a close frame to every open connection and closes
+// it. It is registered with http.Server.RegisterOnShutdown because hijacked
+// WebSocket connections are not closed by http.Server.Shutdown itself.
+func (gs *GameServer) CloseConnections() {
+	closeMessage := websocket.FormatCloseMessage(websocket.CloseServiceRestart, "server shutting down")
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+	for client := range gs.connections {
+		client.conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second))
+		client.conn.Close()
+	}
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// serverSnapshot is the room state written to snapshot.json, so a restarted
+// server can pick up its rooms and running races where they were.
+type serverSnapshot struct {
+	SavedAt int64                    `json:"savedAt"`
+	Rooms   map[string]*roomSnapshot `json:"rooms"`
+}
+
+type roomSnapshot struct {
+	Settings RoomSettings     `json:"settings"`
+	Players  []playerSnapshot `json:"players"`
+	Game     *gameSnapshot    `json:"game,omitempty"`
+}
+
+// playerSnapshot identifies a player by its session, the client id changes
+// with every connection.
+type playerSnapshot struct {
+	Session       string `json:"session"`
+	Username      string `json:"username"`
+	Ready         bool   `json:"ready"`
+	Authenticated bool   `json:"authenticated"`
+}
+
+type gameSnapshot struct {
+	MatchId  string   `json:"matchId"`
+	Text     string   `json:"text"`
+	Words    []string `json:"words"`
+	Language string   `json:"language"`
+	Mode     string   `json:"mode"`
+	// Difficulty the text was generated with.
+	Difficulty Difficulty `json:"difficulty"`
+	Seed       uint64     `json:"seed,omitempty"`
+	StartTime  int64      `json:"startTime"`
+	InGame     []string   `json:"inGame"`   // sessions racing
+	Finished   []string   `json:"finished"` // sessions in finishing order
+	// SuddenDeath races keep the players out of the race, by session in the
+	// order they typed a wrong word.
+	SuddenDeath bool     `json:"suddenDeath,omitempty"`
+	Failed      []string `json:"failed,omitempty"`
+	// Elimination is the series the race is a round of.
+	Elimination *eliminationSnapshot `json:"elimination,omitempty"`
+	// Progress holds the remaining words and word times per session.
+	Progress map[string]*progressSnapshot `json:"progress"`
+}
+
+type eliminationSnapshot struct {
+	Round      int      `json:"round"`
+	Remaining  []string `json:"remaining"`  // sessions
+	Eliminated []string `json:"eliminated"` // usernames, in the order they dropped out
+}
+
+type progressSnapshot struct {
+	RemainingWords []string `json:"remainingWords"`
+	WordTimes      []int64  `json:"wordTimes"`
+}
+
+func (gs *GameServer) snapshotPath() string {
+	return filepath.Join(gs.cfg.DataDir, "snapshot.json")
+}
+
+// RunSnapshots saves the room state every SnapshotInterval until stop is closed.
+func (gs *GameServer) RunSnapshots(stop <-chan struct{}) {
+	ticker := time.NewTicker(gs.cfg.SnapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := gs.SaveSnapshot(); err != nil {
+				gs.log.Error("saving snapshot failed", "err", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// SaveSnapshot writes the current rooms and active races to disk. Bots and
+// clients of other nodes are left out.
+func (gs *GameServer) SaveSnapshot() error {
+	gs.snapshotMutex.Lock()
+	defer gs.snapshotMutex.Unlock()
+	gs.mutex.Lock()
+	snapshot := &serverSnapshot{SavedAt: time.Now().UTC().UnixMilli(), Rooms: make(map[string]*roomSnapshot)}
+	for room, members := range gs.rooms {
+		roomState := &roomSnapshot{Settings: *gs.roomSettingsLocked(room)}
+		for _, client := range members {
+			if client.isBot || client.homeNode != "" {
+				continue
+			}
+			roomState.Players = append(roomState.Players, playerSnapshot{
+				Session:       client.session,
+				Username:      client.username,
+				Ready:         client.isReady,
+				Authenticated: client.authenticated,
+			})
+		}
+		// Relay teams are not saved, a relay race is lost on restart.
+		if game, exists := gs.games[room]; exists && game.IsActive && game.relay == nil {
+			roomState.Game = snapshotGame(game)
+		}
+		if len(roomState.Players) > 0 || roomState.Game != nil || gs.cfg.Rooms[room] != nil {
+			snapshot.Rooms[room] = roomState
+		}
+	}
+	gs.mutex.Unlock()
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	temp := gs.snapshotPath() + ".tmp"
+	if err := os.WriteFile(temp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temp, gs.snapshotPath())
+}
+
+func snapshotGame(game *GameState) *gameSnapshot {
+	state := &gameSnapshot{
+		MatchId:    game.MatchId,
+		Text:       game.Text,
+		Words:      game.wordList,
+		Language:   game.language,
+		Mode:       game.mode,
+		Difficulty: game.difficulty,
+		Seed:       game.seed,
+		StartTime:  game.StartTime,
+		Progress:   make(map[string]*progressSnapshot),
+	}
+	for id, client := range game.InGameUsers {
+		if client.isBot || client.homeNode != "" {
+			continue
+		}
+		state.InGame = append(state.InGame, client.session)
+		if record, ok := game.PlayerProgress[id]; ok {
+			state.Progress[client.session] = &progressSnapshot{RemainingWords: record.remainedWords, WordTimes: record.wordTimes}
+		}
+	}
+	for position := 1; position <= len(game.leaderBoard); position++ {
+		if client, ok := game.leaderBoard[strconv.Itoa(position)]; ok && !client.isBot && client.homeNode == "" {
+			state.Finished = append(state.Finished, client.session)
+		}
+	}
+	state.SuddenDeath = game.suddenDeath
+	failed := make([]string, len(game.failed))
+	for id, order := range game.failed {
+		if client, ok := game.InGameUsers[id]; ok && !client.isBot && client.homeNode == "" {
+			failed[order-1] = client.session
+		}
+	}
+	for _, session := range failed {
+		if session != "" {
+			state.Failed = append(state.Failed, session)
+		}
+	}
+	if series := game.elimination; series != nil {
+		state.Elimination = &eliminationSnapshot{Round: series.round, Eliminated: series.eliminated}
+		for _, client := range series.remaining {
+			if !client.isBot && client.homeNode == "" {
+				state.Elimination.Remaining = append(state.Elimination.Remaining, client.session)
+			}
+		}
+	}
+	return state
+}
+
+// RestoreSnapshot loads the rooms saved by the previous run. Players come
+// back as detached clients that wait SessionResumeTimeout for their owner to
+// resume the session; races keep their start time and go on.
+func (gs *GameServer) RestoreSnapshot() error {
+	data, err := os.ReadFile(gs.snapshotPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snapshot serverSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+
+	players, races := 0, 0
+	for room, roomState := range snapshot.Rooms {
+		detached := make(map[string]*Client)
+		gs.mutex.Lock()
+		settings := roomState.Settings
+		gs.settings[room] = &settings
+		if gs.rooms[room] == nil {
+			gs.rooms[room] = make(map[string]*Client)
+		}
+		for _, player := range roomState.Players {
+			client := &Client{
+				id:            player.Username + "_" + player.Session,
+				session:       player.Session,
+				username:      player.Username,
+				room:          room,
+				isReady:       player.Ready,
+				authenticated: player.Authenticated,
+				outbox:        newOutbox(gs.cfg.SendBufferSize, 0),
+			}
+			detached[player.Session] = client
+			gs.clients[client.id] = client
+			gs.rooms[room][client.id] = client
+			gs.detached[client.session] = client
+			players++
+		}
+		gs.mutex.Unlock()
+		if len(detached) > 0 {
+			gs.roomOwner(room)
+		}
+		if roomState.Game != nil && len(detached) > 0 {
+			gs.restoreGame(room, roomState.Game, detached)
+			races++
+		}
+	}
+	gs.log.Info("snapshot restored", "saved", time.UnixMilli(snapshot.SavedAt).UTC(), "rooms", len(snapshot.Rooms), "players", players, "races", races)
+	go gs.expireDetached(time.Now().Add(gs.cfg.SessionResumeTimeout))
+	return nil
+}
+
+func (gs *GameServer) restoreGame(room string, state *gameSnapshot, detached map[string]*Client) {
+	game := &GameState{
+		Text:           state.Text,
+		StartTime:      state.StartTime,
+		IsActive:       true,
+		PlayerProgress: make(map[string]*PlayerWordRecord),
+		leaderBoard:    make(map[string]*Client),
+		TotalWords:     len(state.Words),
+		InGameUsers:    make(map[string]*Client),
+		wordList:       state.Words,
+		language:       state.Language,
+		MatchId:        state.MatchId,
+		progress:       make(map[string]*racerProgress),
+		mode:           state.Mode,
+		difficulty:     state.Difficulty,
+		seed:           state.Seed,
+		normalization:  gs.normalization(state.Language),
+		suddenDeath:    state.SuddenDeath,
+		failed:         make(map[string]int),
+	}
+	if game.mode == "" {
+		game.mode = modeClassic
+	}
+	for _, session := range state.InGame {
+		client, ok := detached[session]
+		if !ok {
+			continue
+		}
+		game.InGameUsers[client.id] = client
+		record := &PlayerWordRecord{username: client.username, remainedWords: state.Words}
+		if progress, ok := state.Progress[session]; ok {
+			record.remainedWords, record.wordTimes = progress.RemainingWords, progress.WordTimes
+		}
+		game.PlayerProgress[client.id] = record
+	}
+	for i, session := range state.Finished {
+		if client, ok := detached[session]; ok {
+			game.leaderBoard[strconv.Itoa(i+1)] = client
+		}
+	}
+	for _, session := range state.Failed {
+		if client, ok := detached[session]; ok {
+			game.failed[client.id] = len(game.failed) + 1
+			entry := &racerProgress{Userid: client.username, Eliminated: true}
+			if record, ok := game.PlayerProgress[client.id]; ok && game.TotalWords > 0 {
+				entry.Percentage = (game.TotalWords - len(record.remainedWords)) * 100 / game.TotalWords
+			}
+			game.progress[client.username] = entry
+		}
+	}
+	if saved := state.Elimination; saved != nil {
+		series := &elimination{round: saved.Round, remaining: make(map[string]*Client), eliminated: saved.Eliminated}
+		if series.eliminated == nil {
+			series.eliminated = []string{}
+		}
+		for _, session := range saved.Remaining {
+			if client, ok := detached[session]; ok {
+				series.remaining[client.id] = client
+			}
+		}
+		game.elimination = series
+	}
+	gs.mutex.Lock()
+	gs.games[room] = game
+	gs.mutex.Unlock()
+	racesStarted.Inc()
+	if gs.cfg.ProgressTick > 0 {
+		go gs.runProgressTicker(room, game)
+	}
+	// The end timer of a race somebody already finished did not survive the restart.
+	if first, ok := game.leaderBoard["1"]; ok {
+		gs.endGame(first, game.MatchId)
+	}
+	gs.log.Info("race restored", "room", room, "match", game.MatchId, "players", len(game.InGameUsers))
+}
+
+// expireDetached removes the restored players that did not resume their
+// session by deadline.
+func (gs *GameServer) expireDetached(deadline time.Time) {
+	time.Sleep(time.Until(deadline))
+	gs.mutex.Lock()
+	expired := make([]*Client, 0, len(gs.detached))
+	for session, client := range gs.detached {
+		expired = append(expired, client)
+		delete(gs.detached, session)
+	}
+	gs.mutex.Unlock()
+	for _, client := range expired {
+		gs.logFor(client).Info("restored session expired")
+		gs.unregister <- client
+	}
+}
+
+// resumeSession hands the restored player of the session given in the
+// message content over to client, which takes its place in the room and
+// race. The reply tells the client whether that worked.
+func (gs *GameServer) resumeSession(client *Client, messageContent json.RawMessage) {
+	var content struct {
+		Session string `json:"sessionId"`
+	}
+	json.Unmarshal(messageContent, &content)
+
+	gs.mutex.Lock()
+	restored, ok := gs.detached[content.Session]
+	if ok {
+		delete(gs.detached, content.Session)
+		delete(gs.rooms[client.room], client.id)
+		delete(gs.clients, client.id)
+		// The restored client's id keeps its progress and standing valid.
+		client.id = restored.id
+		client.session = restored.session
+		client.room = restored.room
+		client.isReady = restored.isReady
+		if !client.authenticated {
+			client.username = restored.username
+		}
+		gs.clients[client.id] = client
+		gs.rooms[client.room][client.id] = client
+		if game, exists := gs.games[client.room]; exists {
+			if _, racing := game.InGameUsers[client.id]; racing {
+				game.InGameUsers[client.id] = client
+			}
+			for position, finisher := range game.leaderBoard {
+				if finisher == restored {
+					game.leaderBoard[position] = client
+				}
+			}
+			if series := game.elimination; series != nil && series.remaining[client.id] != nil {
+				series.remaining[client.id] = client
+			}
+		}
+	}
+	gs.mutex.Unlock()
+
+	reply := struct {
+		Type      string `json:"type"`
+		Resumed   bool   `json:"resumed"`
+		SessionId string `json:"sessionId"`
+		Room      string `json:"room,omitempty"`
+	}{
+		Type:      "resume",
+		Resumed:   ok,
+		SessionId: client.session,
+	}
+	if !ok {
+		messageBytes, _ := json.Marshal(reply)
+		gs.send(client, messageBytes)
+		return
+	}
+	restored.outbox.close("")
+	reply.Room = client.room
+	reply.SessionId = client.session
+	messageBytes, _ := json.Marshal(reply)
+	gs.send(client, messageBytes)
+	gs.logFor(client).Info("session resumed")
+	if game, exists := gs.games[client.room]; exists && game.IsActive {
+		if _, racing := game.InGameUsers[client.id]; racing {
+			gs.resendStart(client, game)
+			return
+		}
+	}
+	gs.roomStatus(client)
+}
+
+// resendStart sends the startGame message of a running race to a player that
+// resumed it, with the number of words the player had already completed.
+func (gs *GameServer) resendStart(client *Client, game *GameState) {
+	completed := 0
+	if record, ok := game.PlayerProgress[client.id]; ok {
+		completed = game.TotalWords - len(record.remainedWords)
+	}
+	gs.send(client, gs.startMessage(game, completed))
+	if game.elimination != nil {
+		gs.send(client, gs.roundMessage(game))
+	}
+}
+
+// sendSession tells a new client the session id it can resume after the
+// server restarted.
+func (gs *GameServer) sendSession(client *Client) {
+	sessionMessage := struct {
+		Type            string `json:"type"`
+		SessionId       string `json:"sessionId"`
+		ProtocolVersion int    `json:"protocolVersion"`
+	}{
+		Type:            "session",
+		SessionId:       client.session,
+		ProtocolVersion: protocolVersion,
+	}
+	messageBytes, _ := json.Marshal(sessionMessage)
+	gs.send(client, messageBytes)
+}
+
+// snapshotsEnabled reports whether room state is saved across restarts.
+func (gs *GameServer) snapshotsEnabled() bool {
+	return gs.cfg.DataDir != "" && gs.cfg.SnapshotInterval > 0
+}
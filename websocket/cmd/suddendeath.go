@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+// failWord ends the race of a client that typed a wrong word in a sudden
+// death room. Its progress stays where it was and it ranks below everybody
+// that finishes.
+func (gs *GameServer) failWord(client *Client, game *GameState, expected string, got string) {
+	gs.mutex.Lock()
+	if _, ok := game.failed[client.id]; ok {
+		gs.mutex.Unlock()
+		return
+	}
+	game.failed[client.id] = len(game.failed) + 1
+	completed := game.TotalWords - len(game.PlayerProgress[client.id].remainedWords)
+	percentage := 0
+	if game.TotalWords > 0 {
+		percentage = completed * 100 / game.TotalWords
+	}
+	entry, ok := game.progress[client.username]
+	if !ok {
+		// No progress was reported yet, start from how far the racer got.
+		entry = &racerProgress{Userid: client.username, Percentage: percentage}
+		game.progress[client.username] = entry
+	}
+	entry.Eliminated = true
+	game.progressChanged = true
+	gs.mutex.Unlock()
+
+	gs.logFor(client).Info("player eliminated", "expected", expected, "got", got, "completed", completed)
+	eliminatedMessage := struct {
+		Type       string `json:"type"`
+		Userid     string `json:"userid"`
+		MatchId    string `json:"matchId"`
+		Expected   string `json:"expected"`
+		Got        string `json:"got"`
+		Percentage int    `json:"percentage"`
+	}{
+		Type:       "playerEliminated",
+		Userid:     client.username,
+		MatchId:    game.MatchId,
+		Expected:   expected,
+		Got:        got,
+		Percentage: percentage,
+	}
+	messageBytes, _ := json.Marshal(eliminatedMessage)
+	gs.broadcastToRoom(client.room, messageBytes)
+
+	if gs.everyoneDone(client.room, game) {
+		gs.closeMatch(client.room, "finished")
+		endGameMessage, _ := json.Marshal(&GameMessage{Type: "endGame"})
+		gs.broadcastToRoom(client.room, endGameMessage)
+	}
+}
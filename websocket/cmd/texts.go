@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"unicode"
+
+	"golang.org/x/exp/rand"
+)
+
+// Where the text of a generated race comes from.
+const (
+	sourceWords  = "words"  // random dictionary words
+	sourceQuote  = "quote"  // consecutive sentences of the language corpus
+	sourceMarkov = "markov" // sentences made up by the n-gram model of the corpus
+)
+
+var textSources = map[string]bool{
+	sourceWords:  true,
+	sourceQuote:  true,
+	sourceMarkov: true,
+}
+
+// maxSeed keeps seeds exact in JavaScript clients, which read JSON numbers
+// as float64.
+const maxSeed = 1<<53 - 1
+
+// newSeed picks the seed of a race text, never 0.
+func newSeed() uint64 {
+	for {
+		if seed := rand.Uint64() & maxSeed; seed != 0 {
+			return seed
+		}
+	}
+}
+
+// newRand is the source every choice of a race text is drawn from, so the
+// same seed always gives the same text.
+func newRand(seed uint64) *rand.Rand {
+	return rand.New(rand.NewSource(seed))
+}
+
+const (
+	ngramOrder        = 2
+	markovSentenceMax = 40 // words, in case the model runs in circles
+	sentenceEnd       = ""
+)
+
+// languageTexts are the word list and the corpus of a language, and the
+// model trained on the corpus.
+type languageTexts struct {
+	words     []string
+	sentences [][]string
+	model     *ngramModel
+}
+
+// ngramModel maps the last ngramOrder words of a sentence to every word that
+// followed them in the corpus, as often as it did. The start of a sentence is
+// padded with sentenceEnd and sentenceEnd follows its last word.
+type ngramModel struct {
+	next map[string][]string
+}
+
+func ngramKey(words []string) string {
+	return strings.Join(words, "\x1f")
+}
+
+func trainNgramModel(sentences [][]string) *ngramModel {
+	model := &ngramModel{next: make(map[string][]string)}
+	for _, sentence := range sentences {
+		state := make([]string, ngramOrder)
+		for i := 0; i <= len(sentence); i++ {
+			word := sentenceEnd
+			if i < len(sentence) {
+				word = sentence[i]
+			}
+			key := ngramKey(state)
+			model.next[key] = append(model.next[key], word)
+			state = append(state[1:], word)
+		}
+	}
+	return model
+}
+
+// sentence makes up one sentence. The model only holds slices, so the same
+// rng state always gives the same sentence.
+func (model *ngramModel) sentence(rng *rand.Rand) []string {
+	var sentence []string
+	state := make([]string, ngramOrder)
+	for len(sentence) < markovSentenceMax {
+		choices := model.next[ngramKey(state)]
+		if len(choices) == 0 {
+			break
+		}
+		word := choices[rng.Intn(len(choices))]
+		if word == sentenceEnd {
+			break
+		}
+		sentence = append(sentence, word)
+		state = append(state[1:], word)
+	}
+	return sentence
+}
+
+// generate makes up whole sentences until there are at least wordCount words.
+func (model *ngramModel) generate(rng *rand.Rand, wordCount int) []string {
+	var words []string
+	for len(words) < wordCount {
+		sentence := model.sentence(rng)
+		if len(sentence) == 0 {
+			break
+		}
+		words = append(words, sentence...)
+	}
+	return words
+}
+
+// quote takes consecutive sentences from a random one on, wrapping around,
+// until there are at least wordCount words. The corpus must not be empty.
+func (texts *languageTexts) quote(rng *rand.Rand, wordCount int) []string {
+	var words []string
+	start := rng.Intn(len(texts.sentences))
+	for i := 0; i < len(texts.sentences) && len(words) < wordCount; i++ {
+		words = append(words, texts.sentences[(start+i)%len(texts.sentences)]...)
+	}
+	return words
+}
+
+// loadLanguageTexts reads the word list and the corpus of lang in its normal
+// form and trains the model. Either file may be missing, but not both.
+func loadLanguageTexts(lang *Language, textsDir string) (*languageTexts, error) {
+	sentences, err := readSentences(lang.corpusPath(textsDir), lang)
+	if err != nil {
+		return nil, err
+	}
+	words, err := readWords(lang.wordsPath(textsDir), lang)
+	if err != nil {
+		return nil, err
+	}
+	if words == nil {
+		words = corpusWords(sentences)
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("neither %s nor %s has any words", lang.wordsPath(textsDir), lang.corpusPath(textsDir))
+	}
+	return &languageTexts{words: words, sentences: sentences, model: trainNgramModel(sentences)}, nil
+}
+
+// readLines calls line for every non-empty line of path in the normal form
+// of lang. A missing file has no lines.
+func readLines(path string, lang *Language, line func(string)) error {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if text := lang.Normalization.text(normalizeText(scanner.Text())); text != "" {
+			line(text)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	return nil
+}
+
+func readSentences(path string, lang *Language) ([][]string, error) {
+	var sentences [][]string
+	err := readLines(path, lang, func(line string) {
+		sentences = append(sentences, lang.splitWords(line))
+	})
+	return sentences, err
+}
+
+func readWords(path string, lang *Language) ([]string, error) {
+	var words []string
+	err := readLines(path, lang, func(line string) {
+		words = append(words, lang.splitWords(line)...)
+	})
+	return words, err
+}
+
+// corpusWords are the distinct words of sentences without the punctuation
+// around them, in the order they first appear.
+func corpusWords(sentences [][]string) []string {
+	var words []string
+	seen := make(map[string]bool)
+	for _, sentence := range sentences {
+		for _, word := range sentence {
+			word = strings.TrimFunc(word, unicode.IsPunct)
+			if word != "" && !seen[word] {
+				seen[word] = true
+				words = append(words, word)
+			}
+		}
+	}
+	return words
+}
+
+// LoadTexts loads the word lists and corpora of all languages. Languages
+// that fail to load are left out, their rooms can't race.
+func (gs *GameServer) LoadTexts() error {
+	texts := make(map[string]*languageTexts)
+	var errs []error
+	for code, lang := range gs.cfg.Languages {
+		loaded, err := loadLanguageTexts(lang, gs.cfg.TextsDir)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("language %s: %w", code, err))
+			continue
+		}
+		texts[code] = loaded
+		gs.log.Info("language loaded", "language", code, "words", len(loaded.words), "sentences", len(loaded.sentences), "states", len(loaded.model.next))
+	}
+	gs.texts = texts
+	return errors.Join(errs...)
+}
+
+// corpusText is the text of a quote or markov race in lang.
+func (gs *GameServer) corpusText(lang *Language, source string, wordCount int, rng *rand.Rand) (string, []string, error) {
+	corpus, ok := gs.texts[lang.Code]
+	if !ok || len(corpus.sentences) == 0 {
+		return "", nil, fmt.Errorf("no corpus for language %q", lang.Code)
+	}
+	var words []string
+	if source == sourceQuote {
+		words = corpus.quote(rng, wordCount)
+	} else {
+		words = corpus.model.generate(rng, wordCount)
+	}
+	if len(words) == 0 {
+		return "", nil, fmt.Errorf("the %s corpus made an empty text", lang.Code)
+	}
+	return lang.joinWords(words), words, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testLanguage(t *testing.T, code string) (*Language, []string) {
+	t.Helper()
+	lang := defaultConfig().Languages[code]
+	lang.Code = code
+	texts, err := loadLanguageTexts(lang, "../texts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lang, texts.words
+}
+
+func TestGenerateCompetitionTextSeed(t *testing.T) {
+	tests := []struct {
+		language   string
+		wordCount  int
+		difficulty Difficulty
+		seed       uint64
+		want       string
+	}{
+		{"en", 10, Difficulty{}, 42, "outside prepositions demonstrating graves outside reunions steep fascinating prepositions Star"},
+		{"en", 12, Difficulty{Punctuation: true, Capitalization: true, Numbers: true, Symbols: true}, 2, "Speed devoted, Star [reunions] Yeah. Prepositions introductory Star introductory You 8774! <7090>!"},
+		{"fa", 8, Difficulty{Punctuation: true, Numbers: true}, 6, "کوه پرنده آسمان ماه دوست، سلام ۹۱۶۵. خانه!"},
+	}
+	for _, test := range tests {
+		lang, words := testLanguage(t, test.language)
+		display, result := generateCompetitionText(lang, words, test.wordCount, test.difficulty, newRand(test.seed))
+		if display != test.want {
+			t.Errorf("%s with seed %d: got %q, want %q", test.language, test.seed, display, test.want)
+		}
+		if len(result) != test.wordCount || strings.Join(result, " ") != display {
+			t.Errorf("%s with seed %d: words %q don't make up %q", test.language, test.seed, result, display)
+		}
+	}
+}
+
+func testSentences() [][]string {
+	var sentences [][]string
+	for _, line := range []string{"the cat sat on the mat.", "the dog sat on the rug.", "a cat ran to the dog."} {
+		sentences = append(sentences, strings.Split(line, " "))
+	}
+	return sentences
+}
+
+func TestMarkovSeed(t *testing.T) {
+	model := trainNgramModel(testSentences())
+	want := []string{"the", "dog", "sat", "on", "the", "mat.", "the", "dog", "sat", "on", "the", "mat."}
+	for range 2 {
+		if got := model.generate(newRand(1), 12); !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestQuoteSeed(t *testing.T) {
+	texts := &languageTexts{sentences: testSentences()}
+	want := []string{"the", "dog", "sat", "on", "the", "rug.", "a", "cat", "ran", "to", "the", "dog."}
+	if got := texts.quote(newRand(5), 8); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
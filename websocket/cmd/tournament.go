@@ -0,0 +1,628 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// errTournamentNotSaved is returned by CreateTournament when the new
+// tournament could not be written to disk.
+var errTournamentNotSaved = errors.New("the tournament could not be saved")
+
+// Tournament is a bracket of rounds. Every round splits the remaining players
+// into heats, each heat races in its own room, and the best players of every
+// heat move on to the next round. The winner of the single heat of the last
+// round is the champion.
+type Tournament struct {
+	Id           string             `json:"id"`
+	Name         string             `json:"name"`
+	Participants []string           `json:"participants"`
+	Rounds       []*TournamentRound `json:"rounds"`
+	StartAt      time.Time          `json:"startAt"`
+	Status       string             `json:"status"` // scheduled, running or finished
+	CurrentRound int                `json:"currentRound"`
+	Champion     string             `json:"champion,omitempty"`
+}
+
+// TournamentRound holds the rules of a round and, once it started, its heats.
+type TournamentRound struct {
+	Name     string `json:"name"`
+	HeatSize int    `json:"heatSize"` // at most this many players race in one heat
+	Advance  int    `json:"advance"`  // the best this many players of each heat move on
+	// BestOf is the number of races per heat. A heat ends early once a player
+	// won the majority of them.
+	BestOf int `json:"bestOf"`
+	// Points are awarded by finishing position in every race, the default
+	// gives the winner as many points as there are players in the heat and
+	// one point less for each position after that.
+	Points       []int   `json:"points,omitempty"`
+	BreakSeconds int     `json:"breakSeconds"` // pause between the previous round and this one
+	Heats        []*Heat `json:"heats,omitempty"`
+}
+
+// Heat is a group of players racing each other in one room.
+type Heat struct {
+	Room     string         `json:"room"`
+	Players  []string       `json:"players"`
+	Status   string         `json:"status"` // waiting, racing or finished
+	StartAt  time.Time      `json:"startAt"`
+	Results  [][]string     `json:"results"` // finishing order of every race
+	Points   map[string]int `json:"points"`
+	Wins     map[string]int `json:"wins"`
+	Advanced []string       `json:"advanced,omitempty"`
+
+	tournament *Tournament
+	round      *TournamentRound
+}
+
+// tournaments runs the tournaments of a server. Its mutex guards every
+// Tournament, TournamentRound and Heat it holds.
+type tournaments struct {
+	mutex  sync.Mutex
+	byId   map[string]*Tournament
+	heats  map[string]*Heat // by room
+	path   string           // file the tournaments are saved to, empty keeps them in memory
+	timers map[string]*time.Timer
+}
+
+func newTournaments(dir string) *tournaments {
+	store := &tournaments{
+		byId:   make(map[string]*Tournament),
+		heats:  make(map[string]*Heat),
+		timers: make(map[string]*time.Timer),
+	}
+	if dir != "" {
+		store.path = filepath.Join(dir, "tournaments.json")
+	}
+	return store
+}
+
+// validate checks the rules and that the last round is a single heat that
+// decides the champion.
+func (t *Tournament) validate() error {
+	var errs []error
+	if t.Name == "" {
+		errs = append(errs, errors.New("name is required"))
+	}
+	seen := make(map[string]bool)
+	for _, participant := range t.Participants {
+		if participant == "" || seen[participant] {
+			errs = append(errs, fmt.Errorf("participant %q is empty or listed twice", participant))
+		}
+		seen[participant] = true
+	}
+	if len(t.Participants) < 2 {
+		errs = append(errs, errors.New("a tournament needs at least two participants"))
+	}
+	if len(t.Rounds) == 0 {
+		errs = append(errs, errors.New("a tournament needs at least one round"))
+	}
+	players := len(t.Participants)
+	for i, round := range t.Rounds {
+		if round.HeatSize < 2 {
+			errs = append(errs, fmt.Errorf("round %d: heatSize must be at least 2", i+1))
+			continue
+		}
+		if round.Advance < 1 || round.Advance >= round.HeatSize {
+			errs = append(errs, fmt.Errorf("round %d: advance must be between 1 and heatSize-1", i+1))
+			continue
+		}
+		if round.BestOf < 1 {
+			errs = append(errs, fmt.Errorf("round %d: bestOf must be at least 1", i+1))
+		}
+		if round.BreakSeconds < 0 {
+			errs = append(errs, fmt.Errorf("round %d: breakSeconds must not be negative", i+1))
+		}
+		heats := (players + round.HeatSize - 1) / round.HeatSize
+		if i == len(t.Rounds)-1 && heats != 1 {
+			errs = append(errs, fmt.Errorf("round %d: the last round has %d players, more than one heat", i+1, players))
+		}
+		next := 0
+		for heat := 0; heat < heats; heat++ {
+			size := players / heats
+			if heat < players%heats {
+				size++
+			}
+			next += min(round.Advance, size)
+		}
+		players = next
+	}
+	return errors.Join(errs...)
+}
+
+// pointsFor returns the points of a finishing position, starting at 1.
+func (r *TournamentRound) pointsFor(position int, players int) int {
+	if len(r.Points) > 0 {
+		if position <= len(r.Points) {
+			return r.Points[position-1]
+		}
+		return 0
+	}
+	return max(players-position+1, 0)
+}
+
+// standings orders the players of a heat by races won, then points, then
+// best single finish.
+func (h *Heat) standings() []string {
+	best := make(map[string]int)
+	for _, result := range h.Results {
+		for i, player := range result {
+			if best[player] == 0 || i+1 < best[player] {
+				best[player] = i + 1
+			}
+		}
+	}
+	players := append([]string(nil), h.Players...)
+	sort.SliceStable(players, func(i, j int) bool {
+		a, b := players[i], players[j]
+		if h.Wins[a] != h.Wins[b] {
+			return h.Wins[a] > h.Wins[b]
+		}
+		if h.Points[a] != h.Points[b] {
+			return h.Points[a] > h.Points[b]
+		}
+		if best[a] != best[b] {
+			return best[a] != 0 && (best[b] == 0 || best[a] < best[b])
+		}
+		return a < b
+	})
+	return players
+}
+
+// decided reports whether the heat has raced enough.
+func (h *Heat) decided() bool {
+	if len(h.Results) >= h.round.BestOf {
+		return true
+	}
+	for _, wins := range h.Wins {
+		if wins > h.round.BestOf/2 {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateTournament validates and schedules t.
+func (gs *GameServer) CreateTournament(t *Tournament) error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+	t.Id = uuid.New().String()[:8]
+	t.Status = "scheduled"
+	if t.StartAt.IsZero() {
+		t.StartAt = time.Now().UTC()
+	}
+	for _, round := range t.Rounds {
+		round.Heats = nil
+	}
+	store := gs.tournaments
+	store.mutex.Lock()
+	store.byId[t.Id] = t
+	store.scheduleLocked(t.Id, time.Until(t.StartAt), func() { gs.startRound(t, 0) })
+	if err := store.saveLocked(); err != nil {
+		store.stopLocked(t.Id)
+		delete(store.byId, t.Id)
+		store.mutex.Unlock()
+		return fmt.Errorf("%w: %v", errTournamentNotSaved, err)
+	}
+	store.mutex.Unlock()
+	gs.log.Info("tournament created", "tournament", t.Id, "name", t.Name, "participants", len(t.Participants), "start", t.StartAt)
+	return nil
+}
+
+// startRound seeds the players of round into heats and opens their rooms.
+// Heats race once all their players are ready or JoinWindow passed.
+func (gs *GameServer) startRound(t *Tournament, index int) {
+	store := gs.tournaments
+	store.mutex.Lock()
+	if t.Status == "finished" || len(t.Rounds[index].Heats) > 0 {
+		store.mutex.Unlock()
+		return
+	}
+	players := t.Participants
+	if index > 0 {
+		players = nil
+		for _, heat := range t.Rounds[index-1].Heats {
+			players = append(players, heat.Advanced...)
+		}
+	}
+	round := t.Rounds[index]
+	t.Status = "running"
+	t.CurrentRound = index
+	count := (len(players) + round.HeatSize - 1) / round.HeatSize
+	round.Heats = make([]*Heat, count)
+	for i := range round.Heats {
+		round.Heats[i] = &Heat{
+			Room:    fmt.Sprintf("tournament-%s-r%d-h%d", t.Id, index+1, i+1),
+			Status:  "waiting",
+			StartAt: time.Now().UTC().Add(gs.cfg.TournamentJoinWindow),
+			Points:  make(map[string]int),
+			Wins:    make(map[string]int),
+		}
+	}
+	for i, seeded := range snakeSeed(players, count) {
+		round.Heats[i].Players = seeded
+	}
+	for _, heat := range round.Heats {
+		heat.tournament, heat.round = t, round
+		store.heats[heat.Room] = heat
+		room := heat.Room
+		store.scheduleLocked(room, gs.cfg.TournamentJoinWindow, func() { gs.startHeatRace(room) })
+	}
+	gs.saveTournamentsLocked(t)
+	store.mutex.Unlock()
+
+	gs.mutex.Lock()
+	for _, heat := range round.Heats {
+		if gs.rooms[heat.Room] == nil {
+			gs.rooms[heat.Room] = make(map[string]*Client)
+		}
+	}
+	gs.mutex.Unlock()
+	for _, heat := range round.Heats {
+		gs.roomOwner(heat.Room)
+	}
+	gs.log.Info("tournament round started", "tournament", t.Id, "round", index+1, "heats", len(round.Heats))
+	gs.broadcastTournament(t)
+}
+
+// snakeSeed splits players, best first, into count heats: the first count
+// players go to one heat each, the next count in reverse order, and so on.
+// This spreads the strongest players of the last round and keeps the heats
+// within one player of each other.
+func snakeSeed(players []string, count int) [][]string {
+	heats := make([][]string, count)
+	for i, player := range players {
+		heat := i % (2 * count)
+		if heat >= count {
+			heat = 2*count - 1 - heat
+		}
+		heats[heat] = append(heats[heat], player)
+	}
+	return heats
+}
+
+// heatStartable is consulted by readyToStart: a heat room only races when
+// its heat is waiting and every one of its players is in the room and ready.
+func (gs *GameServer) heatStartable(room string) (isHeat bool, startable bool) {
+	store := gs.tournaments
+	store.mutex.Lock()
+	heat, ok := store.heats[room]
+	if !ok {
+		store.mutex.Unlock()
+		return false, true
+	}
+	players := append([]string(nil), heat.Players...)
+	waiting := heat.Status == "waiting"
+	store.mutex.Unlock()
+	if !waiting {
+		return true, false
+	}
+	present := make(map[string]bool)
+	gs.mutex.Lock()
+	for _, client := range gs.rooms[room] {
+		present[client.username] = client.isReady
+	}
+	gs.mutex.Unlock()
+	for _, player := range players {
+		if !present[player] {
+			return true, false
+		}
+	}
+	return true, true
+}
+
+// startHeatRace starts the next race of the heat in room, unless it is
+// already racing. A heat nobody showed up for is recorded as an empty race.
+func (gs *GameServer) startHeatRace(room string) {
+	// The heat stays waiting and is armed again after the restart.
+	if gs.refuseWhileDraining(room) {
+		return
+	}
+	store := gs.tournaments
+	store.mutex.Lock()
+	heat, ok := store.heats[room]
+	if !ok || heat.Status != "waiting" {
+		store.mutex.Unlock()
+		return
+	}
+	heat.Status = "racing"
+	store.stopLocked(room)
+	gs.saveTournamentsLocked(heat.tournament)
+	store.mutex.Unlock()
+
+	gs.mutex.Lock()
+	players := len(gs.rooms[room])
+	gs.mutex.Unlock()
+	if players == 0 {
+		gs.heatRaceEnded(room, nil)
+		return
+	}
+	gs.startNewGame(room)
+	gs.broadcastTournament(heat.tournament)
+}
+
+// tournamentRaceEnded is called by closeMatch to score races of heat rooms.
+func (gs *GameServer) tournamentRaceEnded(room string, game *GameState) {
+	var finishers []string
+	for position := 1; position <= len(game.leaderBoard); position++ {
+		if client, ok := game.leaderBoard[strconv.Itoa(position)]; ok && !client.isBot {
+			finishers = append(finishers, client.username)
+		}
+	}
+	gs.heatRaceEnded(room, finishers)
+}
+
+func (gs *GameServer) heatRaceEnded(room string, finishers []string) {
+	store := gs.tournaments
+	store.mutex.Lock()
+	heat, ok := store.heats[room]
+	if !ok || heat.Status != "racing" {
+		store.mutex.Unlock()
+		return
+	}
+	t := heat.tournament
+	entered := make(map[string]bool)
+	for _, player := range heat.Players {
+		entered[player] = true
+	}
+	var result []string
+	for _, player := range finishers {
+		if entered[player] {
+			result = append(result, player)
+		}
+	}
+	heat.Results = append(heat.Results, result)
+	for i, player := range result {
+		heat.Points[player] += heat.round.pointsFor(i+1, len(heat.Players))
+	}
+	if len(result) > 0 {
+		heat.Wins[result[0]]++
+	}
+
+	nextRound := -1
+	if !heat.decided() {
+		heat.Status = "waiting"
+		heat.StartAt = time.Now().UTC().Add(gs.cfg.TournamentRaceBreak)
+		store.scheduleLocked(room, gs.cfg.TournamentRaceBreak, func() { gs.startHeatRace(room) })
+	} else {
+		heat.Status = "finished"
+		heat.Advanced = heat.standings()[:min(heat.round.Advance, len(heat.Players))]
+		roundDone := true
+		for _, other := range heat.round.Heats {
+			roundDone = roundDone && other.Status == "finished"
+		}
+		switch {
+		case roundDone && t.CurrentRound == len(t.Rounds)-1:
+			t.Status = "finished"
+			t.Champion = heat.standings()[0]
+			gs.log.Info("tournament finished", "tournament", t.Id, "champion", t.Champion)
+		case roundDone:
+			nextRound = t.CurrentRound + 1
+			store.scheduleLocked(t.Id, time.Duration(t.Rounds[nextRound].BreakSeconds)*time.Second, func() { gs.startRound(t, nextRound) })
+		}
+	}
+	gs.saveTournamentsLocked(t)
+	store.mutex.Unlock()
+	gs.log.Info("tournament race scored", "tournament", t.Id, "room", room, "finishers", len(result))
+	gs.broadcastTournament(t)
+}
+
+// scheduleLocked runs f after delay, replacing what was scheduled under key.
+// The caller must hold the tournaments mutex.
+func (store *tournaments) scheduleLocked(key string, delay time.Duration, f func()) {
+	store.stopLocked(key)
+	store.timers[key] = time.AfterFunc(delay, f)
+}
+
+func (store *tournaments) stopLocked(key string) {
+	if timer, ok := store.timers[key]; ok {
+		timer.Stop()
+		delete(store.timers, key)
+	}
+}
+
+// saveTournamentsLocked saves the tournaments after a change to t. A failed
+// write is logged and the bracket lives on in memory, the next change tries
+// again. The caller must hold the tournaments mutex.
+func (gs *GameServer) saveTournamentsLocked(t *Tournament) {
+	if err := gs.tournaments.saveLocked(); err != nil {
+		gs.log.Error("saving tournaments failed", "tournament", t.Id, "err", err)
+	}
+}
+
+// saveLocked writes every tournament to disk. The caller must hold the
+// tournaments mutex.
+func (store *tournaments) saveLocked() error {
+	if store.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(store.byId)
+	if err != nil {
+		return err
+	}
+	temp := store.path + ".tmp"
+	if err := os.WriteFile(temp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temp, store.path)
+}
+
+// LoadTournaments reads the saved tournaments and picks up the unfinished
+// ones: scheduled tournaments keep their start time, and heats that were
+// waiting or whose race did not survive the restart race again after the
+// race break.
+func (gs *GameServer) LoadTournaments() error {
+	store := gs.tournaments
+	if store.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(store.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := json.Unmarshal(data, &store.byId); err != nil {
+		return err
+	}
+	for _, t := range store.byId {
+		for _, round := range t.Rounds {
+			for _, heat := range round.Heats {
+				heat.tournament, heat.round = t, round
+				store.heats[heat.Room] = heat
+			}
+		}
+		switch t.Status {
+		case "scheduled":
+			store.scheduleLocked(t.Id, time.Until(t.StartAt), func() { gs.startRound(t, 0) })
+		case "running":
+			roundDone := true
+			for _, heat := range t.Rounds[t.CurrentRound].Heats {
+				if heat.Status == "finished" {
+					continue
+				}
+				roundDone = false
+				if game, exists := gs.games[heat.Room]; heat.Status == "racing" && exists && game.IsActive {
+					continue
+				}
+				heat.Status = "waiting"
+				room := heat.Room
+				store.scheduleLocked(room, gs.cfg.TournamentRaceBreak, func() { gs.startHeatRace(room) })
+			}
+			if next := t.CurrentRound + 1; roundDone && next < len(t.Rounds) {
+				store.scheduleLocked(t.Id, time.Duration(t.Rounds[next].BreakSeconds)*time.Second, func() { gs.startRound(t, next) })
+			}
+		}
+	}
+	return nil
+}
+
+// tournamentView returns a copy of t safe to encode without the lock.
+func (gs *GameServer) tournamentView(id string) (*Tournament, bool) {
+	store := gs.tournaments
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	t, ok := store.byId[id]
+	if !ok {
+		return nil, false
+	}
+	data, _ := json.Marshal(t)
+	var view Tournament
+	json.Unmarshal(data, &view)
+	return &view, true
+}
+
+// isHeatRoom reports whether room belongs to a tournament heat.
+func (gs *GameServer) isHeatRoom(room string) bool {
+	store := gs.tournaments
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	_, ok := store.heats[room]
+	return ok
+}
+
+// canJoinHeat reports whether username may enter room. Only the players of
+// a heat may join its room, and requiresAuth makes sure the name is theirs.
+func (gs *GameServer) canJoinHeat(room string, username string) bool {
+	store := gs.tournaments
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	heat, ok := store.heats[room]
+	if !ok {
+		return true
+	}
+	for _, player := range heat.Players {
+		if player == username {
+			return true
+		}
+	}
+	return false
+}
+
+// broadcastTournament sends the bracket to the players in the heat rooms of
+// t and to every client that asked for it.
+func (gs *GameServer) broadcastTournament(t *Tournament) {
+	view, ok := gs.tournamentView(t.Id)
+	if !ok {
+		return
+	}
+	update := struct {
+		Type       string      `json:"type"`
+		Tournament *Tournament `json:"tournament"`
+	}{
+		Type:       "tournamentUpdate",
+		Tournament: view,
+	}
+	messageBytes, _ := json.Marshal(update)
+	queued := newOutgoing(messageBytes)
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+	sent := make(map[*Client]bool)
+	for _, round := range view.Rounds {
+		for _, heat := range round.Heats {
+			for _, client := range gs.rooms[heat.Room] {
+				sent[client] = true
+				gs.sendOutgoing(client, queued)
+			}
+		}
+	}
+	for client := range gs.tournamentWatchers[t.Id] {
+		if !sent[client] {
+			gs.sendOutgoing(client, queued)
+		}
+	}
+}
+
+// watchTournament answers a tournament message with the bracket and keeps
+// the client updated until it disconnects.
+func (gs *GameServer) watchTournament(client *Client, messageContent json.RawMessage) {
+	var content struct {
+		Id string `json:"id"`
+	}
+	json.Unmarshal(messageContent, &content)
+	view, ok := gs.tournamentView(content.Id)
+	if !ok {
+		gs.sendError(client, "unknown tournament")
+		return
+	}
+	gs.mutex.Lock()
+	if gs.tournamentWatchers[view.Id] == nil {
+		gs.tournamentWatchers[view.Id] = make(map[*Client]bool)
+	}
+	gs.tournamentWatchers[view.Id][client] = true
+	gs.mutex.Unlock()
+	update := struct {
+		Type       string      `json:"type"`
+		Tournament *Tournament `json:"tournament"`
+	}{
+		Type:       "tournamentUpdate",
+		Tournament: view,
+	}
+	messageBytes, _ := json.Marshal(update)
+	gs.send(client, messageBytes)
+}
+
+// unwatchTournaments stops the tournament updates for a client that left.
+func (gs *GameServer) unwatchTournaments(client *Client) {
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+	for id, watchers := range gs.tournamentWatchers {
+		delete(watchers, client)
+		if len(watchers) == 0 {
+			delete(gs.tournamentWatchers, id)
+		}
+	}
+}
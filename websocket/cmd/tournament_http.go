@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HandleCreateTournament schedules the tournament in the request body.
+func (gs *GameServer) HandleCreateTournament(w http.ResponseWriter, r *http.Request) {
+	if !gs.authorizeAdmin(w, r) {
+		return
+	}
+	var t Tournament
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&t); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if err := gs.CreateTournament(&t); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errTournamentNotSaved) {
+			gs.log.Error("creating tournament failed", "name", t.Name, "err", err)
+			status = http.StatusInternalServerError
+		}
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+	view, _ := gs.tournamentView(t.Id)
+	writeJSON(w, http.StatusCreated, view)
+}
+
+// HandleListTournaments lists every tournament without its heats.
+func (gs *GameServer) HandleListTournaments(w http.ResponseWriter, r *http.Request) {
+	type summary struct {
+		Id       string    `json:"id"`
+		Name     string    `json:"name"`
+		Status   string    `json:"status"`
+		StartAt  time.Time `json:"startAt"`
+		Champion string    `json:"champion,omitempty"`
+	}
+	store := gs.tournaments
+	store.mutex.Lock()
+	list := make([]summary, 0, len(store.byId))
+	for _, t := range store.byId {
+		list = append(list, summary{Id: t.Id, Name: t.Name, Status: t.Status, StartAt: t.StartAt, Champion: t.Champion})
+	}
+	store.mutex.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].StartAt.Before(list[j].StartAt) })
+	writeJSON(w, http.StatusOK, list)
+}
+
+// HandleGetTournament returns the bracket of one tournament.
+func (gs *GameServer) HandleGetTournament(w http.ResponseWriter, r *http.Request) {
+	view, ok := gs.tournamentView(r.PathValue("id"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown tournament"})
+		return
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+// HandleStartTournament starts a scheduled tournament right away.
+func (gs *GameServer) HandleStartTournament(w http.ResponseWriter, r *http.Request) {
+	if !gs.authorizeAdmin(w, r) {
+		return
+	}
+	store := gs.tournaments
+	store.mutex.Lock()
+	t, ok := store.byId[r.PathValue("id")]
+	scheduled := ok && t.Status == "scheduled"
+	if scheduled {
+		store.stopLocked(t.Id)
+	}
+	store.mutex.Unlock()
+	switch {
+	case !ok:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown tournament"})
+	case !scheduled:
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "tournament already started"})
+	default:
+		gs.startRound(t, 0)
+		view, _ := gs.tournamentView(t.Id)
+		writeJSON(w, http.StatusOK, view)
+	}
+}
+
+// authorizeAdmin checks the bearer token of organizer requests.
+func (gs *GameServer) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if gs.cfg.AdminToken == "" {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "tournament administration is disabled"})
+		return false
+	}
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || subtle.ConstantTimeCompare([]byte(token), []byte(gs.cfg.AdminToken)) != 1 {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid admin token"})
+		return false
+	}
+	return true
+}
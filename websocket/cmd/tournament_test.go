@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTournamentValidate(t *testing.T) {
+	players := func(n int) []string {
+		var names []string
+		for i := 1; i <= n; i++ {
+			names = append(names, "p"+string(rune('0'+i)))
+		}
+		return names
+	}
+	tests := []struct {
+		name         string
+		participants []string
+		rounds       []*TournamentRound
+		valid        bool
+	}{
+		{"single heat", players(4), []*TournamentRound{{HeatSize: 4, Advance: 1, BestOf: 1}}, true},
+		// 7 players race in heats of 4 and 3, 2 move on from each.
+		{"odd players", players(7), []*TournamentRound{{HeatSize: 4, Advance: 2, BestOf: 1}, {HeatSize: 4, Advance: 1, BestOf: 3}}, true},
+		// 5 players make heats of 2, 2 and 1, the lone player gets a bye.
+		{"bye", players(5), []*TournamentRound{{HeatSize: 2, Advance: 1, BestOf: 1}, {HeatSize: 3, Advance: 1, BestOf: 1}}, true},
+		{"last round has two heats", players(5), []*TournamentRound{{HeatSize: 4, Advance: 1, BestOf: 1}}, false},
+		{"advance too large", players(4), []*TournamentRound{{HeatSize: 4, Advance: 4, BestOf: 1}}, false},
+		{"no races", players(4), []*TournamentRound{{HeatSize: 4, Advance: 1}}, false},
+		{"one participant", players(1), []*TournamentRound{{HeatSize: 2, Advance: 1, BestOf: 1}}, false},
+		{"participant twice", []string{"p1", "p2", "p1"}, []*TournamentRound{{HeatSize: 3, Advance: 1, BestOf: 1}}, false},
+		{"no rounds", players(4), nil, false},
+	}
+	for _, test := range tests {
+		tournament := &Tournament{Name: "cup", Participants: test.participants, Rounds: test.rounds}
+		if err := tournament.validate(); (err == nil) != test.valid {
+			t.Errorf("%s: got %v, want valid %v", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestSnakeSeed(t *testing.T) {
+	tests := []struct {
+		players []string
+		heats   int
+		want    [][]string
+	}{
+		{[]string{"a", "b", "c", "d"}, 1, [][]string{{"a", "b", "c", "d"}}},
+		{[]string{"a", "b", "c", "d", "e", "f", "g"}, 2, [][]string{{"a", "d", "e"}, {"b", "c", "f", "g"}}},
+		{[]string{"a", "b", "c", "d", "e"}, 3, [][]string{{"a"}, {"b", "e"}, {"c", "d"}}},
+		{[]string{"a", "b", "c"}, 2, [][]string{{"a"}, {"b", "c"}}},
+	}
+	for _, test := range tests {
+		if got := snakeSeed(test.players, test.heats); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v in %d heats: got %v, want %v", test.players, test.heats, got, test.want)
+		}
+	}
+}
+
+func TestHeatStandings(t *testing.T) {
+	heat := &Heat{
+		Players: []string{"a", "b", "c", "d", "e"},
+		Results: [][]string{{"b", "c", "a"}, {"c", "a", "b", "d"}},
+		Points:  map[string]int{"a": 7, "b": 7, "c": 9, "d": 2},
+		Wins:    map[string]int{"b": 1, "c": 1},
+	}
+	// c and b won a race each and c has more points, a and b tie on points
+	// but b won, d finished once and e never did.
+	want := []string{"c", "b", "a", "d", "e"}
+	if got := heat.standings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	heat = &Heat{
+		Players: []string{"b", "a", "c"},
+		Results: [][]string{{"c", "a"}, {"b"}},
+		Points:  map[string]int{"a": 2, "b": 3, "c": 3},
+		Wins:    map[string]int{"b": 1, "c": 1},
+	}
+	// b and c tie on wins, points and best finish, the name decides.
+	want = []string{"b", "c", "a"}
+	if got := heat.standings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestHeatDecided(t *testing.T) {
+	tests := []struct {
+		bestOf  int
+		results [][]string
+		wins    map[string]int
+		want    bool
+	}{
+		{1, nil, nil, false},
+		{1, [][]string{nil}, nil, true}, // nobody showed up
+		{3, [][]string{{"a"}}, map[string]int{"a": 1}, false},
+		{3, [][]string{{"a"}, {"a"}}, map[string]int{"a": 2}, true},
+		{3, [][]string{{"a"}, {"b"}}, map[string]int{"a": 1, "b": 1}, false},
+		{3, [][]string{{"a"}, {"b"}, nil}, map[string]int{"a": 1, "b": 1}, true},
+		{4, [][]string{{"a"}, {"a"}}, map[string]int{"a": 2}, false},
+	}
+	for _, test := range tests {
+		heat := &Heat{Results: test.results, Wins: test.wins, round: &TournamentRound{BestOf: test.bestOf}}
+		if got := heat.decided(); got != test.want {
+			t.Errorf("best of %d after %v: got %v, want %v", test.bestOf, test.results, got, test.want)
+		}
+	}
+}
+
+// Five players race in heats of 2, 2 and 1. The player of the single heat
+// moves on without racing and the winners of the others join them in the
+// final.
+func TestTournamentAdvancement(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	gs := newTestNode(t, ctx, newMemoryBroker(), "a")
+	gs.cfg.TournamentJoinWindow = time.Hour
+
+	tournament := &Tournament{
+		Name:         "cup",
+		Participants: []string{"p1", "p2", "p3", "p4", "p5"},
+		Rounds: []*TournamentRound{
+			{HeatSize: 2, Advance: 1, BestOf: 1},
+			{HeatSize: 3, Advance: 1, BestOf: 3},
+		},
+	}
+	if err := gs.CreateTournament(tournament); err != nil {
+		t.Fatal(err)
+	}
+	store := gs.tournaments
+	defer func() {
+		store.mutex.Lock()
+		for key := range store.timers {
+			store.stopLocked(key)
+		}
+		store.mutex.Unlock()
+	}()
+	heats := func(round int) []*Heat {
+		store.mutex.Lock()
+		defer store.mutex.Unlock()
+		return append([]*Heat(nil), tournament.Rounds[round].Heats...)
+	}
+	race := func(heat *Heat, finishers ...string) {
+		store.mutex.Lock()
+		heat.Status = "racing"
+		store.mutex.Unlock()
+		gs.heatRaceEnded(heat.Room, finishers)
+	}
+
+	eventually(t, "the first round did not start", func() bool { return len(heats(0)) == 3 })
+	first := heats(0)
+	var seeded [][]string
+	for _, heat := range first {
+		seeded = append(seeded, heat.Players)
+	}
+	if want := [][]string{{"p1"}, {"p2", "p5"}, {"p3", "p4"}}; !reflect.DeepEqual(seeded, want) {
+		t.Fatalf("seeded %v, want %v", seeded, want)
+	}
+	race(first[0])
+	race(first[1], "p5", "p2")
+	race(first[2], "p3", "p4", "p1") // p1 is not in this heat
+	for i, want := range []string{"p1", "p5", "p3"} {
+		if advanced := first[i].Advanced; !reflect.DeepEqual(advanced, []string{want}) {
+			t.Errorf("heat %d advanced %v, want %s", i+1, advanced, want)
+		}
+	}
+
+	eventually(t, "the final did not start", func() bool { return len(heats(1)) == 1 })
+	final := heats(1)[0]
+	if want := []string{"p1", "p5", "p3"}; !reflect.DeepEqual(final.Players, want) {
+		t.Fatalf("final players %v, want %v", final.Players, want)
+	}
+	race(final, "p3", "p1", "p5")
+	if view, _ := gs.tournamentView(tournament.Id); view.Status != "running" {
+		t.Fatalf("finished after one race of a best of 3")
+	}
+	race(final, "p3", "p5", "p1")
+	view, _ := gs.tournamentView(tournament.Id)
+	if view.Status != "finished" || view.Champion != "p3" {
+		t.Errorf("status %s, champion %q, want finished with p3", view.Status, view.Champion)
+	}
+	if points := view.Rounds[1].Heats[0].Points; !reflect.DeepEqual(points, map[string]int{"p1": 3, "p3": 6, "p5": 3}) {
+		t.Errorf("final points %v", points)
+	}
+}
+
+func TestCreateTournamentNotSaved(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	gs := newTestNode(t, ctx, newMemoryBroker(), "a")
+	gs.tournaments.path = filepath.Join(t.TempDir(), "missing", "tournaments.json")
+
+	tournament := &Tournament{
+		Name:         "cup",
+		Participants: []string{"p1", "p2"},
+		Rounds:       []*TournamentRound{{HeatSize: 2, Advance: 1, BestOf: 1}},
+		StartAt:      time.Now().Add(time.Hour),
+	}
+	if err := gs.CreateTournament(tournament); !errors.Is(err, errTournamentNotSaved) {
+		t.Fatalf("got %v, want errTournamentNotSaved", err)
+	}
+	if _, ok := gs.tournamentView(tournament.Id); ok {
+		t.Error("a tournament that was not saved is still scheduled")
+	}
+}